@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Run probes every host at the probe interval until the context is done,
+// caching the results for Collect to report.
+func (e *DNSCollector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.probeInterval)
+	defer ticker.Stop()
+
+	for {
+		e.probeCached(ctx, e.probeJitter)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeCached probes every host, replacing the cached results. When rotating
+// resolvers or adapting the probe interval, the results of probes not run
+// are kept for hosts that are still configured and enabled.
+func (e *DNSCollector) probeCached(ctx context.Context, jitter time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, e.scrapeTimeout+jitter)
+	defer cancel()
+
+	cache := map[probeKey][]prometheus.Metric{}
+	if e.resolverRotation != nil || e.maxProbeInterval > 0 {
+		hosts := map[string]bool{}
+		for _, host := range e.Hosts() {
+			hosts[host.Name] = host.IsEnabled()
+		}
+
+		e.cacheMutex.Lock()
+		for key, metrics := range e.cache {
+			if hosts[key.host] {
+				cache[key] = metrics
+			}
+		}
+		e.cacheMutex.Unlock()
+	}
+
+	round := newConsistencyRound()
+	e.forEachProbe(ctx, jitter, func(host HostConfig, key probeKey) {
+		metrics := collectMetrics(func(ch chan<- prometheus.Metric) {
+			round.add(key, e.resolveHost(ctx, ch, host, key))
+		})
+
+		e.cacheMutex.Lock()
+		cache[key] = metrics
+		e.cacheMutex.Unlock()
+	})
+	e.recordConsistency(round)
+
+	e.cacheMutex.Lock()
+	e.cache = cache
+	e.cacheMutex.Unlock()
+}
+
+func (e *DNSCollector) collectCached(ch chan<- prometheus.Metric) {
+	e.cacheMutex.Lock()
+	defer e.cacheMutex.Unlock()
+
+	for _, metrics := range e.cache {
+		for _, metric := range metrics {
+			ch <- metric
+		}
+	}
+}
+
+// collectMetrics returns the metrics sent by collect.
+func collectMetrics(collect func(ch chan<- prometheus.Metric)) []prometheus.Metric {
+	ch := make(chan prometheus.Metric)
+	done := make(chan []prometheus.Metric)
+
+	go func() {
+		metrics := []prometheus.Metric{}
+		for metric := range ch {
+			metrics = append(metrics, metric)
+		}
+		done <- metrics
+	}()
+
+	collect(ch)
+	close(ch)
+
+	return <-done
+}
+
+// probeDue returns whether the key is due to be probed, which it always is
+// unless adapting the probe interval.
+func (e *DNSCollector) probeDue(key probeKey) bool {
+	if e.maxProbeInterval == 0 {
+		return true
+	}
+
+	e.nextProbeMutex.Lock()
+	defer e.nextProbeMutex.Unlock()
+
+	return !time.Now().Before(e.nextProbe[key])
+}
+
+// scheduleProbe schedules the next probe of the key after the TTL of the
+// response, clamped between the probe interval and the maximum, and returns
+// when it is due.
+func (e *DNSCollector) scheduleProbe(key probeKey, msg *dns.Msg) time.Time {
+	interval := e.probeInterval
+	if ttl, ok := minTTL(msg); ok {
+		interval = min(max(time.Duration(ttl)*time.Second, e.probeInterval), e.maxProbeInterval)
+	}
+	next := time.Now().Add(interval)
+
+	e.nextProbeMutex.Lock()
+	e.nextProbe[key] = next
+	e.nextProbeMutex.Unlock()
+
+	return next
+}
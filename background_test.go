@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRunStopsWhenCancelled(t *testing.T) {
+	config := testConfig("example.org")
+	config.ProbeInterval = 10 * time.Millisecond
+
+	// The lookup blocks until its context is done, so that the prober is
+	// cancelled mid-probe as well as between probes.
+	probed := make(chan struct{}, 1)
+	collector := newFakeCollector(t, config, fakeResolver(func(ctx context.Context, q Query) (Response, error) {
+		select {
+		case probed <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		return Response{}, ctx.Err()
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		collector.Run(ctx)
+	}()
+
+	select {
+	case <-probed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the prober to probe the host")
+	}
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the prober to return once cancelled")
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type cachedProbe struct {
+	metrics  []prometheus.Metric
+	probedAt time.Time
+}
+
+// resolveHostCached sends the cached metrics of the key if it was probed
+// within the cache TTL, and otherwise probes it and caches the metrics.
+func (e *DNSCollector) resolveHostCached(ctx context.Context, ch chan<- prometheus.Metric, host HostConfig, key probeKey, round *consistencyRound) {
+	e.scrapeCacheMutex.Lock()
+	cached, ok := e.scrapeCache[key]
+	hit := ok && time.Since(cached.probedAt) < e.cacheTTL
+	if hit {
+		e.cacheHitsCount[key] += 1
+	}
+	hits := e.cacheHitsCount[key]
+	e.scrapeCacheMutex.Unlock()
+
+	if !hit {
+		cached = cachedProbe{probedAt: time.Now()}
+		cached.metrics = collectMetrics(func(ch chan<- prometheus.Metric) {
+			round.add(key, e.resolveHost(ctx, ch, host, key))
+		})
+
+		e.scrapeCacheMutex.Lock()
+		e.scrapeCache[key] = cached
+		e.scrapeCacheMutex.Unlock()
+	}
+
+	for _, metric := range cached.metrics {
+		ch <- metric
+	}
+	ch <- prometheus.MustNewConstMetric(e.cacheHits, prometheus.CounterValue, float64(hits), e.labelValues(host, key)...)
+}
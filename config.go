@@ -0,0 +1,1315 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/common/model"
+	"golang.org/x/net/idna"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	HostsEnvVar = "DNS_EXPORTER_HOSTS"
+
+	DefaultTimeout        = 5 * time.Second
+	DefaultConnectTimeout = 2 * time.Second
+	DefaultScrapeTimeout  = 10 * time.Second
+
+	// When max_concurrency isn't configured, it defaults to a number of
+	// probes per available CPU, within bounds, as probes mostly wait on the
+	// network.
+	DefaultConcurrencyPerCPU = 4
+	MinDefaultMaxConcurrency = 10
+	MaxDefaultMaxConcurrency = 100
+
+	DefaultDoHIdleConnTimeout = 90 * time.Second
+
+	DefaultRetryBackoff = 100 * time.Millisecond
+
+	DefaultEDNSBufferSize = 4096
+
+	DefaultLatencyWindow = 10
+	DefaultSuccessWindow = 20
+
+	DefaultWebhookCooldown = time.Minute
+)
+
+type HostConfig struct {
+	Name string `yaml:"name"`
+	// RecordTypes overrides the globally configured record types, and may
+	// also be given as types. Hosts looked up with the PTR record type must
+	// be IP addresses.
+	RecordTypes []string `yaml:"record_types"`
+	ExpectedIPs []string `yaml:"expected_ips"`
+	// ExpectedCIDRs are networks every address returned is expected to be
+	// within, such as to check that a host resolves to internal addresses.
+	ExpectedCIDRs []string `yaml:"expected_cidrs"`
+	// Expected are the values expected in the answers, keyed by record type,
+	// such as mail exchangers for MX or an SPF record for TXT.
+	Expected map[string]ExpectedConfig `yaml:"expected"`
+	// MinRecords and MaxRecords, if set, are the range the number of records
+	// returned is expected to be within, such as the size of a pool behind a
+	// load balancer.
+	MinRecords int `yaml:"min_records"`
+	MaxRecords int `yaml:"max_records"`
+	// MinAnswers is the fewest answers a lookup of the host must return to
+	// succeed, defaulting to 1, such as to require several addresses of a
+	// pooled service. Fewer fail with the insufficient_answers error type.
+	MinAnswers int `yaml:"min_answers"`
+	// MaxInfoRecords, if set, caps the info series exposed per record, such
+	// as each SRV target or authority, keeping the most preferred SRV targets
+	// and the first authorities by name. Resolutions returning more are
+	// counted by resolution_records_truncated_total.
+	MaxInfoRecords int `yaml:"max_info_records"`
+	// DNSSEC sets the DO bit on queries, and exposes whether the response was
+	// validated. This is only supported by resolvers that construct the DNS
+	// messages themselves, such as in raw mode.
+	DNSSEC bool `yaml:"dnssec"`
+	// Class overrides the globally configured query class.
+	Class string `yaml:"class"`
+	// ECS are client subnets, as CIDRs, to probe the host as if querying
+	// from, such as to compare GeoDNS answers across regions. The host is
+	// probed once per subnet, labelled by it. A single subnet may be given
+	// as a string. This is only supported by resolvers that construct the
+	// DNS messages themselves, such as in raw mode.
+	ECS stringList `yaml:"ecs"`
+	// RecursionDesired and CheckingDisabled set the RD and CD bits of the
+	// queries, which default to set and clear, such as to query an
+	// authoritative server directly, or to get answers that failed DNSSEC
+	// validation. Either may be given as a list, such as [true, false], to
+	// probe the host with each, labelled by the flags set. These are only
+	// supported by resolvers that construct the DNS messages themselves,
+	// such as in raw mode.
+	RecursionDesired boolList `yaml:"recursion_desired"`
+	CheckingDisabled boolList `yaml:"checking_disabled"`
+	// Mode and Resolver override the global mode and resolvers for the
+	// host, such as to probe internal hosts over plain DNS and external ones
+	// over DoH from one exporter. A host given a resolver isn't rotated
+	// through the global resolvers.
+	Mode     string `yaml:"mode"`
+	Resolver string `yaml:"resolver"`
+	// Timeout overrides the global timeout for lookups of the host, such as
+	// for distant resolvers that are legitimately slow.
+	Timeout time.Duration `yaml:"timeout"`
+	// Policy, one of spf or dmarc, checks that the TXT records of the host
+	// include a well-formed record of the email policy. DMARC records are
+	// published at _dmarc.<domain>, so that is the host to configure.
+	Policy string `yaml:"policy"`
+	// IgnoreErrors are error types, such as nxdomain for a host expected not
+	// to exist, that are treated as successful resolutions of the host.
+	IgnoreErrors []string `yaml:"ignore_errors"`
+	// MinTTL and MaxTTL, if either is set, bound the minimum TTL of the
+	// answers, such as to catch records whose short TTL would defeat caches.
+	// TTLs are only known to resolvers that construct the DNS messages
+	// themselves, such as in raw mode.
+	MinTTL time.Duration `yaml:"min_ttl"`
+	MaxTTL time.Duration `yaml:"max_ttl"`
+	// SLOThreshold, if set, counts the resolutions of the host taking longer
+	// than this.
+	SLOThreshold time.Duration `yaml:"slo_threshold"`
+	// PublicOnly counts resolutions of the host returning loopback,
+	// link-local, private, or unspecified addresses as suspicious, such as
+	// of a public name rebound to an internal address.
+	PublicOnly bool `yaml:"public_only"`
+	// DoubleCheck repeats every probe of the host immediately, counting the
+	// answers changing between the two as a flap, as a lightweight check for
+	// cache poisoning of stable records. It doubles the queries made.
+	DoubleCheck bool `yaml:"double_check"`
+	// Enabled can be set to false to stop probing the host, such as during
+	// maintenance, while keeping its counters.
+	Enabled *bool `yaml:"enabled"`
+
+	// Group, if set, is a logical group of hosts, such as the services of a
+	// team, whose resolutions and errors are also summed across the group.
+	Group string `yaml:"group"`
+
+	// Labels are static labels added to every metric of the host. As every
+	// metric carries the union of label keys across all hosts, with hosts
+	// lacking a key given an empty value, each distinct key and value adds
+	// series, so labels should be kept few and low-cardinality.
+	Labels map[string]string `yaml:"labels"`
+}
+
+// IsEnabled returns whether the host is probed, which it is unless disabled.
+func (h HostConfig) IsEnabled() bool {
+	return h.Enabled == nil || *h.Enabled
+}
+
+// UnmarshalYAML allows a host to be given as a plain name, as well as a
+// mapping with per-host settings.
+func (h *HostConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		h.Name = value.Value
+		return nil
+	}
+
+	if err := checkKnownFields(value, reflect.TypeOf(*h), "types"); err != nil {
+		return err
+	}
+
+	type plain HostConfig
+	if err := value.Decode((*plain)(h)); err != nil {
+		return err
+	}
+
+	var alias struct {
+		Types []string `yaml:"types"`
+	}
+	if err := value.Decode(&alias); err != nil {
+		return err
+	}
+	for _, recordType := range alias.Types {
+		if !slices.Contains(h.RecordTypes, recordType) {
+			h.RecordTypes = append(h.RecordTypes, recordType)
+		}
+	}
+
+	return nil
+}
+
+// withDefaults returns the host with each setting it leaves unset taken from
+// the defaults.
+func (h HostConfig) withDefaults(defaults HostConfig) HostConfig {
+	host := reflect.ValueOf(&h).Elem()
+	values := reflect.ValueOf(defaults)
+	for i := 0; i < host.NumField(); i++ {
+		if host.Field(i).IsZero() {
+			host.Field(i).Set(values.Field(i))
+		}
+	}
+
+	return h
+}
+
+// DisplayNameLabel is the label given the Unicode name of a host configured
+// as an internationalized domain name.
+const DisplayNameLabel = "display_name"
+
+// toASCII converts the name of a host given as an internationalized domain
+// name to the ASCII form queried, keeping the original as its display_name
+// label unless the host sets one.
+func (h HostConfig) toASCII() (HostConfig, error) {
+	if isASCII(h.Name) {
+		return h, nil
+	}
+
+	name, err := idna.Lookup.ToASCII(h.Name)
+	if err != nil {
+		return h, fmt.Errorf("host '%s' is not a valid internationalized domain name: %s", h.Name, err)
+	}
+
+	labels := map[string]string{DisplayNameLabel: h.Name}
+	for key, value := range h.Labels {
+		labels[key] = value
+	}
+	h.Name, h.Labels = name, labels
+
+	return h, nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
+
+// stringList is a list of strings that may also be given as a single string.
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = stringList{value.Value}
+		return nil
+	}
+
+	return value.Decode((*[]string)(l))
+}
+
+// boolList is a list of bools that may also be given as a single bool.
+type boolList []bool
+
+func (l *boolList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var b bool
+		if err := value.Decode(&b); err != nil {
+			return err
+		}
+		*l = boolList{b}
+		return nil
+	}
+
+	return value.Decode((*[]bool)(l))
+}
+
+// queryFlags returns every combination of query flags the host is probed
+// with.
+func (h HostConfig) queryFlags() []queryFlags {
+	recursionDesired := []bool(h.RecursionDesired)
+	if len(recursionDesired) == 0 {
+		recursionDesired = []bool{true}
+	}
+	checkingDisabled := []bool(h.CheckingDisabled)
+	if len(checkingDisabled) == 0 {
+		checkingDisabled = []bool{false}
+	}
+
+	flags := []queryFlags{}
+	for _, rd := range recursionDesired {
+		for _, cd := range checkingDisabled {
+			flags = append(flags, queryFlags{noRecursion: !rd, checkingDisabled: cd})
+		}
+	}
+
+	return flags
+}
+
+// ExpectedConfig are values every one of which must be found in the answers.
+// Addresses are compared as IPs, and names case-insensitively, ignoring any
+// trailing dot. TXT values are compared exactly, or as a substring of an
+// answer if Match is substring.
+type ExpectedConfig struct {
+	Values []string `yaml:"values"`
+	Match  string   `yaml:"match"`
+}
+
+// UnmarshalYAML allows expected values to be given as a plain list.
+func (e *ExpectedConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.SequenceNode {
+		return value.Decode(&e.Values)
+	}
+
+	if err := checkKnownFields(value, reflect.TypeOf(*e)); err != nil {
+		return err
+	}
+
+	type plain ExpectedConfig
+	return value.Decode((*plain)(e))
+}
+
+// checkKnownFields returns an error for keys of the mapping that aren't
+// fields of the struct type or extra aliases. Unknown fields are rejected by
+// the config decoder, but not within types decoding themselves from nodes.
+func checkKnownFields(value *yaml.Node, t reflect.Type, extra ...string) error {
+	if value.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	known := map[string]bool{}
+	for _, name := range extra {
+		known[name] = true
+	}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		known[name] = true
+	}
+
+	for i := 0; i < len(value.Content); i += 2 {
+		key := value.Content[i]
+		if !known[key.Value] {
+			return fmt.Errorf("line %d: field %s not found in type %s", key.Line, key.Value, t)
+		}
+	}
+
+	return nil
+}
+
+type Config struct {
+	Hosts []HostConfig `yaml:"hosts"`
+	// Defaults are settings applied to every host that doesn't set them
+	// itself, such as the record types or timeout shared by most hosts. As a
+	// setting left unset can't be told apart from one set to false or zero,
+	// hosts can't turn off a setting, such as dnssec, enabled by default.
+	Defaults HostConfig `yaml:"defaults"`
+	// FileSD are paths of Prometheus file_sd JSON files to read additional
+	// hosts from.
+	FileSD []string `yaml:"file_sd"`
+
+	RecordTypes []string `yaml:"record_types"`
+	// Network selects the address families looked up when no record types
+	// are configured, one or a list of ip, the default, for both, ip4, or ip6.
+	// These are the IP, A, and AAAA record types respectively, and are also
+	// labelled by network, so that the families can be compared side by side.
+	Network   stringList `yaml:"network"`
+	Resolver  string     `yaml:"resolver"`
+	Resolvers []string   `yaml:"resolvers"`
+
+	// ResolverStrategy selects which resolvers each host is probed with: all
+	// (the default), or round_robin, which rotates through the resolvers per
+	// host on every probe, using each in proportion to its weight in
+	// ResolverWeights, which defaults to 1.
+	ResolverStrategy string         `yaml:"resolver_strategy"`
+	ResolverWeights  map[string]int `yaml:"resolver_weights"`
+	// CheckConsistency compares the answers of every resolver for each host,
+	// counting the probes in which they disagree. As it needs every resolver
+	// to be probed together, it cannot be used with round_robin.
+	CheckConsistency bool `yaml:"check_consistency"`
+	// CompareWithSystem also probes every host with the system resolver, in
+	// stdlib mode, labelled resolver="system", to tell problems local to the
+	// exporter's host, such as in resolv.conf, from those of the configured
+	// resolvers. Lookups the stdlib can't make, such as of other classes or
+	// client subnets, aren't compared.
+	CompareWithSystem bool `yaml:"compare_with_system"`
+
+	// Mode selects how resolvers are queried: stdlib (the default), raw,
+	// which queries them directly to expose details such as TTLs, dot, which
+	// queries them over DNS-over-TLS, or doh, in which case each resolver is
+	// a DNS-over-HTTPS endpoint URL.
+	Mode string `yaml:"mode"`
+	// Protocol is the transport used in stdlib and raw mode, either udp or tcp.
+	Protocol string `yaml:"protocol"`
+	// PreferGo uses the pure Go resolver rather than the system's, which is
+	// always the case when querying a specific resolver.
+	PreferGo bool `yaml:"prefer_go"`
+	// ReuseConnections keeps UDP connections to resolvers open between
+	// lookups in stdlib mode.
+	ReuseConnections bool `yaml:"reuse_connections"`
+	// AbsoluteNames looks up every host as an absolute name, so that the
+	// system search domains and ndots option are not applied.
+	AbsoluteNames bool `yaml:"absolute_names"`
+	// DoHUserAgent is the User-Agent sent to DNS-over-HTTPS endpoints,
+	// defaulting to dns-exporter/<version>. DoHHeaders are additional
+	// headers sent on every request, such as for authentication.
+	DoHUserAgent string            `yaml:"doh_user_agent"`
+	DoHHeaders   map[string]string `yaml:"doh_headers"`
+	// DoHIdleConnTimeout is how long idle connections to DNS-over-HTTPS
+	// endpoints are kept open for reuse by later probes.
+	DoHIdleConnTimeout time.Duration `yaml:"doh_idle_conn_timeout"`
+	// SourceAddresses are local IPs that resolvers are queried from, with each
+	// probed from every source and labelled by it, to check each egress path
+	// of a multi-homed host. SourceAddress is shorthand for a single source.
+	SourceAddress   string   `yaml:"source_address"`
+	SourceAddresses []string `yaml:"source_addresses"`
+	// SOCKS5Proxy is the host:port of a SOCKS5 proxy that resolvers are dialled
+	// through. As SOCKS5 only proxies TCP, it requires the tcp protocol, or dot
+	// or doh mode.
+	SOCKS5Proxy string `yaml:"socks5_proxy"`
+	// Class is the class of every query, such as IN, the default, or CH to
+	// query version.bind TXT. Classes other than IN are only supported by
+	// resolvers that construct the DNS messages themselves, such as in raw
+	// mode.
+	Class string `yaml:"class"`
+	// EDNSBufferSize is the UDP buffer size advertised in raw mode.
+	EDNSBufferSize uint16 `yaml:"edns_buffer_size"`
+
+	Timeout time.Duration `yaml:"timeout"`
+	// ConnectTimeout bounds dialling resolvers, separately from the timeout
+	// of the whole lookup, so that slow connections fail fast.
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	ScrapeTimeout  time.Duration `yaml:"scrape_timeout"`
+
+	MaxConcurrency int `yaml:"max_concurrency"`
+
+	// LatencyWindow is the number of recent resolutions the minimum and
+	// maximum latency are reported over.
+	LatencyWindow int `yaml:"latency_window"`
+	// SuccessWindow is the number of recent resolutions the success ratio is
+	// reported over.
+	SuccessWindow int `yaml:"success_window"`
+
+	// ProbeInterval, if set, probes hosts in the background at this interval
+	// and reports the latest results on scrape, rather than probing on every
+	// scrape.
+	ProbeInterval time.Duration `yaml:"probe_interval"`
+	// MaxProbeInterval, if set, adapts the interval of each background probe
+	// to the TTL of its most recent answer, between ProbeInterval and this,
+	// so that long-lived records aren't queried needlessly often. TTLs are
+	// only known to resolvers that construct the DNS messages themselves, so
+	// others are probed at ProbeInterval.
+	MaxProbeInterval time.Duration `yaml:"max_probe_interval"`
+	// ProbeJitter delays the background probes of each host by a random
+	// duration up to this, to spread the load on resolvers.
+	ProbeJitter time.Duration `yaml:"probe_jitter"`
+	// CacheTTL, if set, reports the results of a probe for this long rather
+	// than probing again on every scrape, to protect resolvers from
+	// aggressive scraping. It has no effect when probing in the background.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+
+	// Retries is the number of times a lookup failing with a temporary
+	// error is retried, waiting RetryBackoff before the first retry and
+	// doubling the wait after each one. Truncated UDP responses are always
+	// retried over TCP in raw mode, which doesn't consume a retry.
+	Retries      int           `yaml:"retries"`
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+
+	// SRVTargetInfo exposes an info metric per SRV target, which adds a
+	// series for every target, port, priority, and weight returned.
+	SRVTargetInfo bool `yaml:"srv_target_info"`
+
+	// ProbeAllowedTargets are regular expressions, anchored to match the whole
+	// target, of the targets that may be probed via /probe. If none are
+	// configured, every target is denied.
+	ProbeAllowedTargets []string `yaml:"probe_allowed_targets"`
+
+	// LatencyBuckets are the upper bounds of the latency histogram buckets,
+	// in seconds and ascending, defaulting to DefaultLatencyBuckets.
+	LatencyBuckets []float64 `yaml:"buckets"`
+
+	// NativeHistograms exposes resolution_seconds as a native histogram
+	// rather than with classic buckets. It is only scraped by Prometheus
+	// servers with native histograms enabled.
+	NativeHistograms bool `yaml:"native_histograms"`
+	// Exemplars attaches an exemplar with a trace_id generated for each probe
+	// to the latency histogram, which is also logged with failed lookups.
+	// Exemplars are only exposed to scrapers requesting the OpenMetrics
+	// format.
+	Exemplars bool `yaml:"exemplars"`
+
+	// MaxSeries, if set, refuses to create a collector estimated to expose
+	// more series than this, to guard against large configs overwhelming
+	// Prometheus.
+	MaxSeries int `yaml:"max_series"`
+
+	// DisabledMetrics are the full names of metrics not to expose, such as
+	// dns_exporter_resolution_rcode_total, to reduce cardinality.
+	DisabledMetrics []string `yaml:"disabled_metrics"`
+
+	// Listeners are additional addresses to serve metrics on, each for a
+	// subset of the hosts, such as to keep high-cardinality probes from being
+	// scraped along with the rest.
+	Listeners []ListenerConfig `yaml:"listeners"`
+
+	// WebhookURL, if set, is posted JSON when a probe changes between success
+	// and failure, for notifications sooner than alerting rules evaluate.
+	// Changes within WebhookCooldown of the last one posted for a probe are
+	// held back until it passes, to not post on every flap.
+	WebhookURL      string        `yaml:"webhook_url"`
+	WebhookCooldown time.Duration `yaml:"webhook_cooldown"`
+
+	// CacheHitLabel adds a cache_hit label to the latency histogram, guessed
+	// from the TTL of the response. See cacheHit for the approximation.
+	CacheHitLabel bool `yaml:"cache_hit_label"`
+	// NSID requests the identifier of the answering server, such as one of
+	// those behind an anycast address, and adds it to the latency histogram
+	// as an nsid label. It is only supported by resolvers that construct the
+	// DNS messages themselves, such as in raw mode.
+	NSID bool `yaml:"nsid"`
+	// CaseRandomization randomizes the case of query names, as DNS 0x20
+	// encoding does to make spoofing harder, and counts responses not echoing
+	// the name in exactly the same case. It is only supported by resolvers
+	// that construct the DNS messages themselves, such as in raw mode.
+	CaseRandomization bool `yaml:"case_randomization"`
+}
+
+// ListenerConfig is an additional address serving the metrics of a subset of
+// the hosts, which are probed by a collector of its own instead of the main
+// one, so that they are only served on the listener.
+type ListenerConfig struct {
+	// Address is the address to listen on, such as :9116.
+	Address string `yaml:"address"`
+	// Hosts are the names of the hosts probed, of which there are none unless
+	// listed.
+	Hosts []string `yaml:"hosts"`
+	// DisabledMetrics are metrics not exposed, in addition to those disabled
+	// for every listener.
+	DisabledMetrics []string `yaml:"disabled_metrics"`
+}
+
+// listenerConfig returns the config of the collector backing the listener,
+// probing only its hosts.
+func (c Config) listenerConfig(listener ListenerConfig) Config {
+	config := c
+	config.Hosts = listener.hostsOf(c.Hosts)
+	config.DisabledMetrics = append(slices.Clip(c.DisabledMetrics), listener.DisabledMetrics...)
+	config.Listeners = nil
+
+	return config
+}
+
+// hostsOf returns the hosts served by the listener.
+func (l ListenerConfig) hostsOf(hosts []HostConfig) []HostConfig {
+	served := []HostConfig{}
+	for _, host := range hosts {
+		if slices.Contains(l.Hosts, host.Name) {
+			served = append(served, host)
+		}
+	}
+
+	return served
+}
+
+// unlistenedHosts returns the hosts served by none of the listeners, which
+// are those probed by the main collector.
+func unlistenedHosts(listeners []ListenerConfig, hosts []HostConfig) []HostConfig {
+	unlistened := []HostConfig{}
+	for _, host := range hosts {
+		if !slices.ContainsFunc(listeners, func(listener ListenerConfig) bool { return slices.Contains(listener.Hosts, host.Name) }) {
+			unlistened = append(unlistened, host)
+		}
+	}
+
+	return unlistened
+}
+
+// DefaultMaxConcurrency returns the default maximum number of concurrent
+// probes, proportional to GOMAXPROCS.
+func DefaultMaxConcurrency() int {
+	return min(max(runtime.GOMAXPROCS(0)*DefaultConcurrencyPerCPU, MinDefaultMaxConcurrency), MaxDefaultMaxConcurrency)
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Hosts: []HostConfig{
+			{Name: "example.org"},
+			{Name: "google.com"},
+		},
+		Timeout:        DefaultTimeout,
+		ConnectTimeout: DefaultConnectTimeout,
+		ScrapeTimeout:  DefaultScrapeTimeout,
+
+		LatencyWindow: DefaultLatencyWindow,
+		SuccessWindow: DefaultSuccessWindow,
+
+		WebhookCooldown: DefaultWebhookCooldown,
+
+		RetryBackoff: DefaultRetryBackoff,
+
+		EDNSBufferSize: DefaultEDNSBufferSize,
+	}
+}
+
+// hostMode returns the mode the host is probed in, which defaults to the
+// global mode, then stdlib.
+func (c Config) hostMode(host HostConfig) string {
+	if host.Mode != "" {
+		return host.Mode
+	}
+	if c.Mode != "" {
+		return c.Mode
+	}
+
+	return ModeStdlib
+}
+
+// hostResolverAddresses returns the resolvers the host is probed with.
+func (c Config) hostResolverAddresses(host HostConfig) []string {
+	if host.Resolver != "" {
+		return []string{host.Resolver}
+	}
+
+	return resolverAddresses(c)
+}
+
+// supportsRecordType returns whether the record type can be looked up in the
+// mode. Modes other than stdlib construct the DNS messages themselves, so
+// can look up any type, given by name or number.
+func supportsRecordType(mode, recordType string) bool {
+	if supportedRecordTypes[recordType] {
+		return true
+	}
+	if mode == "" || mode == ModeStdlib {
+		return false
+	}
+
+	_, ok := messageQtype(recordType)
+	return ok
+}
+
+func validateClass(mode, class string) error {
+	qclass, ok := messageQclass(class)
+	if !ok {
+		return fmt.Errorf("unsupported class '%s'", class)
+	}
+	if qclass != dns.ClassINET && (mode == "" || mode == ModeStdlib) {
+		return fmt.Errorf("class '%s' is not supported in stdlib mode", class)
+	}
+
+	return nil
+}
+
+// validateResolver checks the resolver address is valid for the mode.
+func validateResolver(mode, address string) error {
+	if address == SystemResolver {
+		if mode == ModeDoH || mode == ModeDoT {
+			return fmt.Errorf("%s mode requires a resolver address", mode)
+		}
+		return nil
+	}
+
+	if mode == ModeDoH {
+		if _, err := url.ParseRequestURI(address); err != nil {
+			return fmt.Errorf("resolver '%s' is not a valid url: %s", address, err)
+		}
+		return nil
+	}
+
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("resolver '%s' is not a valid host:port: %s", address, err)
+	}
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return fmt.Errorf("resolver '%s' has invalid port '%s'", address, port)
+	}
+
+	return nil
+}
+
+func (e ExpectedConfig) validate(recordType string) error {
+	if !supportedRecordTypes[recordType] {
+		return fmt.Errorf("unsupported record type '%s'", recordType)
+	}
+
+	switch e.Match {
+	case "", MatchExact:
+	case MatchSubstring:
+		if recordType != RecordTypeTXT {
+			return fmt.Errorf("match '%s' is only supported for %s records", e.Match, RecordTypeTXT)
+		}
+	default:
+		return fmt.Errorf("unsupported match '%s'", e.Match)
+	}
+
+	for _, value := range e.Values {
+		if recordTypeHasFamily(recordType, FamilyNone) {
+			continue
+		}
+		if ip := net.ParseIP(value); ip == nil || !recordTypeHasFamily(recordType, ipFamily(ip)) {
+			return fmt.Errorf("'%s' is not a valid address", value)
+		}
+	}
+
+	return nil
+}
+
+// LoadConfig loads a comma-separated list of config files or globs, with -
+// reading from stdin. Later files override the settings of earlier ones,
+// other than hosts, which are merged, with exact duplicates dropped and the
+// last file winning if the same host is configured differently.
+func LoadConfig(paths string) (Config, error) {
+	files, err := expandConfigPaths(paths)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var config Config
+	hosts := []HostConfig{}
+	fileSD := []string{}
+
+	for _, file := range files {
+		config.Hosts = nil
+		config.FileSD = nil
+
+		if err := loadConfigFile(file, &config); err != nil {
+			return Config{}, err
+		}
+
+		hosts = mergeHosts(hosts, config.Hosts, file)
+		fileSD = append(fileSD, config.FileSD...)
+	}
+
+	for i := range hosts {
+		hosts[i], err = hosts[i].withDefaults(config.Defaults).toASCII()
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	config.Hosts = hosts
+	config.FileSD = fileSD
+
+	return config, nil
+}
+
+func expandConfigPaths(paths string) ([]string, error) {
+	files := []string{}
+
+	for _, path := range strings.Split(paths, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config file pattern '%s': %s", path, err)
+		}
+		if len(matches) == 0 {
+			// Not a glob, or one matching nothing, so the path is read as is
+			// for a missing file to be reported.
+			matches = []string{path}
+		}
+
+		files = append(files, matches...)
+	}
+
+	return files, nil
+}
+
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} references in the config file with the values of
+// the environment variables, warning about those that are unset. Unlike
+// os.Expand, bare $VAR references are left alone, so that regular
+// expressions in the config can still use $.
+func expandEnv(data []byte, path string) []byte {
+	return envReference.ReplaceAllFunc(data, func(reference []byte) []byte {
+		name := string(envReference.FindSubmatch(reference)[1])
+
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			slog.Warn("unset environment variable referenced in config file", "variable", name, "file", path)
+		}
+		return []byte(value)
+	})
+}
+
+// StdinConfigFile is the config file path that reads the config from stdin.
+const StdinConfigFile = "-"
+
+func loadConfigFile(path string, config *Config) error {
+	var data []byte
+	var err error
+	if path == StdinConfigFile {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return fmt.Errorf("could not read config file '%s': %s", path, err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(expandEnv(data, path)))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && err != io.EOF {
+		return fmt.Errorf("could not parse config file '%s': %s", path, err)
+	}
+
+	for _, fileSD := range config.FileSD {
+		hosts, err := LoadFileSD(fileSD)
+		if err != nil {
+			return err
+		}
+		config.Hosts = append(config.Hosts, hosts...)
+	}
+
+	return nil
+}
+
+// checkEnabledHosts logs an error if none of the hosts are enabled, so that
+// nothing would be probed, and returns it if rejectEmpty is set.
+func checkEnabledHosts(hosts []HostConfig, rejectEmpty bool) error {
+	if slices.ContainsFunc(hosts, HostConfig.IsEnabled) {
+		return nil
+	}
+
+	err := errors.New("no hosts are enabled")
+	if rejectEmpty {
+		return err
+	}
+	slog.Error("no hosts are enabled, so none will be probed")
+
+	return nil
+}
+
+// dedupeHosts drops hosts with the same name as an earlier one, which would
+// otherwise produce duplicate series, logging a warning listing them.
+func dedupeHosts(hosts []HostConfig) []HostConfig {
+	seen := map[string]bool{}
+	deduped := []HostConfig{}
+	dropped := []string{}
+
+	for _, host := range hosts {
+		if seen[host.Name] {
+			dropped = append(dropped, host.Name)
+			continue
+		}
+		seen[host.Name] = true
+		deduped = append(deduped, host)
+	}
+
+	if len(dropped) > 0 {
+		slog.Warn("dropping duplicate hosts", "hosts", dropped)
+	}
+
+	return deduped
+}
+
+// mergeHosts adds the hosts loaded from file to the existing hosts, dropping
+// exact duplicates and replacing hosts configured differently.
+func mergeHosts(existing, hosts []HostConfig, file string) []HostConfig {
+	index := map[string]int{}
+	for i, host := range existing {
+		index[host.Name] = i
+	}
+
+	merged := existing
+	for _, host := range hosts {
+		i, ok := index[host.Name]
+		if !ok {
+			merged = append(merged, host)
+			continue
+		}
+
+		if !reflect.DeepEqual(merged[i], host) {
+			slog.Warn("host configured differently in multiple config files, using the last", "host", host.Name, "file", file)
+			merged[i] = host
+		}
+	}
+
+	return merged
+}
+
+func (c Config) Validate() error {
+	if len(c.Hosts) == 0 {
+		return errors.New("no hosts configured")
+	}
+	if c.Defaults.Name != "" {
+		return errors.New("defaults cannot set a host name")
+	}
+	for i, host := range c.Hosts {
+		if strings.TrimSpace(host.Name) == "" {
+			return fmt.Errorf("host %d has a blank name", i)
+		}
+		for _, cidr := range host.ECS {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("host '%s' has invalid ecs '%s': %s", host.Name, cidr, err)
+			}
+		}
+		switch host.Mode {
+		case "", ModeStdlib, ModeRaw, ModeDoH, ModeDoT:
+		default:
+			return fmt.Errorf("host '%s' has unsupported mode '%s'", host.Name, host.Mode)
+		}
+		mode := c.hostMode(host)
+		if host.Mode != "" || host.Resolver != "" {
+			for _, address := range c.hostResolverAddresses(host) {
+				if err := validateResolver(mode, address); err != nil {
+					return fmt.Errorf("host '%s' has invalid resolver: %s", host.Name, err)
+				}
+			}
+		}
+		if host.Mode != "" && c.SOCKS5Proxy != "" && mode != ModeDoH && mode != ModeDoT && c.Protocol != ProtocolTCP {
+			return fmt.Errorf("host '%s' has mode '%s', which can't be used with socks5_proxy but over tcp", host.Name, mode)
+		}
+
+		if len(host.ECS) > 0 && mode == ModeStdlib {
+			return fmt.Errorf("host '%s' has ecs, which is not supported in stdlib mode", host.Name)
+		}
+		if !slices.Equal(host.queryFlags(), []queryFlags{{}}) && mode == ModeStdlib {
+			return fmt.Errorf("host '%s' changes query flags, which is not supported in stdlib mode", host.Name)
+		}
+
+		for _, cidr := range host.ExpectedCIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("host '%s' has invalid expected CIDR '%s': %s", host.Name, cidr, err)
+			}
+		}
+
+		if host.MinRecords < 0 || host.MaxRecords < 0 {
+			return fmt.Errorf("host '%s' has a negative record count range", host.Name)
+		}
+		if host.MaxRecords > 0 && host.MinRecords > host.MaxRecords {
+			return fmt.Errorf("host '%s' has min_records greater than max_records", host.Name)
+		}
+		if host.MinAnswers < 0 {
+			return fmt.Errorf("host '%s' has a negative min_answers", host.Name)
+		}
+		if host.MaxInfoRecords < 0 {
+			return fmt.Errorf("host '%s' has a negative max_info_records", host.Name)
+		}
+		if host.Timeout < 0 {
+			return fmt.Errorf("host '%s' has a negative timeout", host.Name)
+		}
+		switch host.Policy {
+		case "", PolicySPF, PolicyDMARC:
+		default:
+			return fmt.Errorf("host '%s' has unsupported policy '%s'", host.Name, host.Policy)
+		}
+		for _, errorType := range host.IgnoreErrors {
+			if !slices.Contains(errorTypes, errorType) {
+				return fmt.Errorf("host '%s' ignores unknown error type '%s'", host.Name, errorType)
+			}
+		}
+		if host.SLOThreshold < 0 {
+			return fmt.Errorf("host '%s' has a negative slo_threshold", host.Name)
+		}
+		if host.MinTTL < 0 || host.MaxTTL < 0 {
+			return fmt.Errorf("host '%s' has a negative ttl bound", host.Name)
+		}
+		if host.MaxTTL > 0 && host.MinTTL > host.MaxTTL {
+			return fmt.Errorf("host '%s' has min_ttl greater than max_ttl", host.Name)
+		}
+
+		for _, recordType := range host.RecordTypes {
+			if !supportsRecordType(mode, recordType) {
+				return fmt.Errorf("host '%s' has unsupported record type '%s'", host.Name, recordType)
+			}
+		}
+
+		if host.Class != "" {
+			if err := validateClass(mode, host.Class); err != nil {
+				return fmt.Errorf("host '%s' has invalid class: %s", host.Name, err)
+			}
+		}
+
+		for recordType, expected := range host.Expected {
+			if err := expected.validate(recordType); err != nil {
+				return fmt.Errorf("host '%s' has invalid expected %s values: %s", host.Name, recordType, err)
+			}
+		}
+
+		recordTypes := host.RecordTypes
+		if len(recordTypes) == 0 {
+			recordTypes = c.RecordTypes
+		}
+		if host.Policy != "" && !slices.Contains(recordTypes, RecordTypeTXT) {
+			return fmt.Errorf("host '%s' must look up TXT records to check its %s policy", host.Name, host.Policy)
+		}
+		for _, recordType := range recordTypes {
+			if recordType == RecordTypePTR && net.ParseIP(host.Name) == nil {
+				return fmt.Errorf("host '%s' must be an IP address to look up PTR records", host.Name)
+			}
+		}
+	}
+
+	for _, key := range hostLabelKeys(c.Hosts) {
+		if !model.LabelName(key).IsValid() {
+			return fmt.Errorf("label '%s' is not a valid label name", key)
+		}
+		if isReservedLabel(key) {
+			return fmt.Errorf("label '%s' is reserved", key)
+		}
+	}
+
+	if c.Class != "" {
+		if err := validateClass(c.Mode, c.Class); err != nil {
+			return err
+		}
+	}
+
+	for _, network := range c.Network {
+		if _, err := networkRecordType(network); err != nil {
+			return err
+		}
+	}
+	if len(c.Network) > 0 && len(c.RecordTypes) > 0 {
+		return errors.New("network cannot be combined with record_types")
+	}
+
+	for _, recordType := range c.RecordTypes {
+		if !supportsRecordType(c.Mode, recordType) {
+			return fmt.Errorf("unsupported record type '%s'", recordType)
+		}
+	}
+
+	for _, address := range resolverAddresses(c) {
+		if err := validateResolver(c.Mode, address); err != nil {
+			return err
+		}
+	}
+
+	switch c.ResolverStrategy {
+	case "", ResolverStrategyAll, ResolverStrategyRoundRobin:
+	default:
+		return fmt.Errorf("unsupported resolver strategy '%s'", c.ResolverStrategy)
+	}
+	if c.NSID && (c.Mode == "" || c.Mode == ModeStdlib) {
+		return errors.New("nsid is not supported in stdlib mode")
+	}
+	if c.CaseRandomization && (c.Mode == "" || c.Mode == ModeStdlib) {
+		return errors.New("case_randomization is not supported in stdlib mode")
+	}
+	if c.CheckConsistency && c.ResolverStrategy == ResolverStrategyRoundRobin {
+		return errors.New("check_consistency cannot be used with the round_robin resolver strategy")
+	}
+	if c.CompareWithSystem && slices.Contains(resolverAddresses(c), SystemResolver) {
+		return errors.New("compare_with_system requires resolvers other than the system one")
+	}
+	for resolver, weight := range c.ResolverWeights {
+		if !slices.Contains(resolverAddresses(c), resolver) {
+			return fmt.Errorf("resolver weight given for unconfigured resolver '%s'", resolver)
+		}
+		if weight <= 0 {
+			return fmt.Errorf("resolver '%s' must have a positive weight", resolver)
+		}
+	}
+
+	for _, source := range sourceAddresses(c) {
+		if source != "" && net.ParseIP(source) == nil {
+			return fmt.Errorf("source address '%s' is not an IP address", source)
+		}
+	}
+
+	if c.SOCKS5Proxy != "" {
+		if _, _, err := net.SplitHostPort(c.SOCKS5Proxy); err != nil {
+			return fmt.Errorf("socks5_proxy '%s' is not a valid host:port: %s", c.SOCKS5Proxy, err)
+		}
+		if c.Mode != ModeDoH && c.Mode != ModeDoT && c.Protocol != ProtocolTCP {
+			return errors.New("socks5_proxy requires the tcp protocol, or dot or doh mode")
+		}
+	}
+
+	if _, err := compileTargetPatterns(c.ProbeAllowedTargets); err != nil {
+		return err
+	}
+
+	if c.MaxConcurrency < 0 {
+		return errors.New("max_concurrency must not be negative")
+	}
+	if c.LatencyWindow < 0 {
+		return errors.New("latency_window must not be negative")
+	}
+	if c.SuccessWindow < 0 {
+		return errors.New("success_window must not be negative")
+	}
+	if c.ProbeInterval < 0 {
+		return errors.New("probe_interval must not be negative")
+	}
+	if c.MaxProbeInterval != 0 && (c.ProbeInterval == 0 || c.MaxProbeInterval < c.ProbeInterval) {
+		return errors.New("max_probe_interval requires probe_interval, and must not be less than it")
+	}
+	if c.DoHIdleConnTimeout < 0 {
+		return errors.New("doh_idle_conn_timeout must not be negative")
+	}
+	if c.CacheTTL < 0 {
+		return errors.New("cache_ttl must not be negative")
+	}
+	if c.ConnectTimeout < 0 {
+		return errors.New("connect_timeout must not be negative")
+	}
+	if c.ProbeJitter < 0 {
+		return errors.New("probe_jitter must not be negative")
+	}
+	for i := 1; i < len(c.LatencyBuckets); i++ {
+		if c.LatencyBuckets[i] <= c.LatencyBuckets[i-1] {
+			return errors.New("buckets must be in ascending order")
+		}
+	}
+	if c.MaxSeries < 0 {
+		return errors.New("max_series must not be negative")
+	}
+	if c.Retries < 0 {
+		return errors.New("retries must not be negative")
+	}
+
+	if c.WebhookURL != "" {
+		u, err := url.Parse(c.WebhookURL)
+		if err != nil {
+			return fmt.Errorf("webhook_url is not a valid URL: %s", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("webhook_url '%s' must be an http or https URL", c.WebhookURL)
+		}
+	}
+	if c.WebhookCooldown < 0 {
+		return errors.New("webhook_cooldown must not be negative")
+	}
+
+	addresses := map[string]bool{}
+	listened := map[string]string{}
+	for i, listener := range c.Listeners {
+		if listener.Address == "" {
+			return fmt.Errorf("listener %d has no address", i)
+		}
+		if addresses[listener.Address] {
+			return fmt.Errorf("listener address '%s' is configured more than once", listener.Address)
+		}
+		addresses[listener.Address] = true
+		for _, name := range listener.Hosts {
+			if !slices.ContainsFunc(c.Hosts, func(host HostConfig) bool { return host.Name == name }) {
+				return fmt.Errorf("listener '%s' serves unconfigured host '%s'", listener.Address, name)
+			}
+			if address, ok := listened[name]; ok && address != listener.Address {
+				return fmt.Errorf("host '%s' is served by listeners '%s' and '%s'", name, address, listener.Address)
+			}
+			listened[name] = listener.Address
+		}
+	}
+
+	return nil
+}
+
+// loadConfig returns the config file at path if given, otherwise the default
+// config with hosts taken from the comma-separated envHosts if set.
+func loadConfig(path, envHosts string) (Config, error) {
+	if path != "" {
+		return LoadConfig(path)
+	}
+
+	config := DefaultConfig()
+	if envHosts != "" {
+		config.Hosts = parseHosts(envHosts)
+	}
+	for i := range config.Hosts {
+		var err error
+		config.Hosts[i], err = config.Hosts[i].toASCII()
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	return config, nil
+}
+
+func parseHosts(s string) []HostConfig {
+	hosts := []HostConfig{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		hosts = append(hosts, HostConfig{Name: name})
+	}
+
+	return hosts
+}
+
+// hostLabelKeys returns the sorted union of label keys across the hosts.
+func hostLabelKeys(hosts []HostConfig) []string {
+	seen := map[string]bool{}
+	keys := []string{}
+
+	for _, host := range hosts {
+		for key := range host.Labels {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+var reservedLabels = []string{"error_type", "family", "rcode", "target", "port", "priority", "weight", "cache_hit", "server", "authority", "error", "nsid", "policy", "rrtype"}
+
+func isReservedLabel(key string) bool {
+	for _, reserved := range append(probeLabels, reservedLabels...) {
+		if key == reserved {
+			return true
+		}
+	}
+
+	return false
+}
+
+const (
+	NetworkIP  = "ip"
+	NetworkIP4 = "ip4"
+	NetworkIP6 = "ip6"
+)
+
+// networkRecordType returns the record type looking up addresses of the
+// network.
+func networkRecordType(network string) (string, error) {
+	switch network {
+	case "", NetworkIP:
+		return RecordTypeIP, nil
+	case NetworkIP4:
+		return RecordTypeA, nil
+	case NetworkIP6:
+		return RecordTypeAAAA, nil
+	default:
+		return "", fmt.Errorf("unsupported network '%s'", network)
+	}
+}
+
+// recordTypeNetwork returns the network of the record type looking up
+// addresses, labelling probes by it, or empty for other record types.
+func recordTypeNetwork(recordType string) string {
+	switch recordType {
+	case RecordTypeIP:
+		return NetworkIP
+	case RecordTypeA:
+		return NetworkIP4
+	case RecordTypeAAAA:
+		return NetworkIP6
+	default:
+		return ""
+	}
+}
+
+const (
+	ResolverStrategyAll        = "all"
+	ResolverStrategyRoundRobin = "round_robin"
+)
+
+// resolverRotation returns the order in which resolvers are rotated through
+// with the round_robin strategy, with each resolver appearing as many times as
+// its weight, interleaved so that heavier resolvers are spread out.
+func resolverRotation(config Config) []string {
+	if config.ResolverStrategy != ResolverStrategyRoundRobin {
+		return nil
+	}
+
+	addresses := resolverAddresses(config)
+	weight := func(address string) int {
+		if w, ok := config.ResolverWeights[address]; ok {
+			return w
+		}
+		return 1
+	}
+
+	maxWeight := 0
+	for _, address := range addresses {
+		maxWeight = max(maxWeight, weight(address))
+	}
+
+	rotation := []string{}
+	for round := 0; round < maxWeight; round++ {
+		for _, address := range addresses {
+			if weight(address) > round {
+				rotation = append(rotation, address)
+			}
+		}
+	}
+
+	return rotation
+}
+
+// sourceAddresses returns the configured source addresses, or the empty
+// source, dialling from any address, if there are none.
+func sourceAddresses(config Config) []string {
+	if len(config.SourceAddresses) > 0 {
+		return config.SourceAddresses
+	}
+
+	return []string{config.SourceAddress}
+}
+
+func resolverAddresses(config Config) []string {
+	if len(config.Resolvers) > 0 {
+		return config.Resolvers
+	}
+
+	if config.Resolver != "" {
+		return []string{config.Resolver}
+	}
+
+	return []string{SystemResolver}
+}
+
+// validateConfig loads the config as loadConfig does, and checks that a
+// collector, including its resolvers, can be created from it.
+func validateConfig(path, envHosts string) error {
+	config, err := loadConfig(path, envHosts)
+	if err != nil {
+		return err
+	}
+
+	_, err = NewDNSCollector(config)
+	return err
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile writes the config to a file in a temporary directory,
+// returning its path.
+func writeConfigFile(t *testing.T, name, config string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatalf("could not write config file: %s", err)
+	}
+
+	return path
+}
+
+func TestParseHosts(t *testing.T) {
+	tests := []struct {
+		hosts    string
+		expected []string
+	}{
+		{hosts: "example.org", expected: []string{"example.org"}},
+		{hosts: "example.org,google.com", expected: []string{"example.org", "google.com"}},
+		{hosts: " example.org , google.com ", expected: []string{"example.org", "google.com"}},
+		{hosts: "example.org,,google.com,", expected: []string{"example.org", "google.com"}},
+		{hosts: " , ", expected: []string{}},
+	}
+
+	for _, test := range tests {
+		if names := hostNames(parseHosts(test.hosts)); !slices.Equal(names, test.expected) {
+			t.Errorf("hosts '%s': expected %v, got %v", test.hosts, test.expected, names)
+		}
+	}
+}
+
+func TestLoadConfigHostsPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "config.yml", "hosts:\n  - name: file.example.org\n")
+
+	tests := []struct {
+		name     string
+		path     string
+		envHosts string
+		expected []string
+	}{
+		{name: "defaults", expected: hostNames(DefaultConfig().Hosts)},
+		{name: "env over defaults", envHosts: "env.example.org, other.example.org", expected: []string{"env.example.org", "other.example.org"}},
+		{name: "file", path: path, expected: []string{"file.example.org"}},
+		{name: "file over env", path: path, envHosts: "env.example.org", expected: []string{"file.example.org"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := loadConfig(test.path, test.envHosts)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if names := hostNames(config.Hosts); !slices.Equal(names, test.expected) {
+				t.Errorf("expected hosts %v, got %v", test.expected, names)
+			}
+		})
+	}
+}
+
+func TestLoadConfigMergesFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, config := range map[string]string{
+		"a.yml": "hosts:\n  - name: a.example.org\n  - name: shared.example.org\n    expected_ips: [192.0.2.1]\n",
+		"b.yml": "hosts:\n  - name: b.example.org\n  - name: a.example.org\n  - name: shared.example.org\n    expected_ips: [192.0.2.2]\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(config), 0o644); err != nil {
+			t.Fatalf("could not write config file: %s", err)
+		}
+	}
+
+	for _, paths := range []string{
+		filepath.Join(dir, "a.yml") + "," + filepath.Join(dir, "b.yml"),
+		filepath.Join(dir, "*.yml"),
+	} {
+		config, err := LoadConfig(paths)
+		if err != nil {
+			t.Fatalf("paths '%s': unexpected error: %s", paths, err)
+		}
+
+		// Exact duplicates are dropped, and the last file wins conflicts.
+		expected := []string{"a.example.org", "shared.example.org", "b.example.org"}
+		if names := hostNames(config.Hosts); !slices.Equal(names, expected) {
+			t.Errorf("paths '%s': expected hosts %v, got %v", paths, expected, names)
+		}
+		if ips := config.Hosts[1].ExpectedIPs; !slices.Equal(ips, []string{"192.0.2.2"}) {
+			t.Errorf("paths '%s': expected the last file's expected_ips [192.0.2.2], got %v", paths, ips)
+		}
+	}
+}
+
+func TestMergeHosts(t *testing.T) {
+	existing := []HostConfig{{Name: "a.example.org"}, {Name: "b.example.org", Timeout: 1}}
+	hosts := []HostConfig{{Name: "a.example.org"}, {Name: "b.example.org", Timeout: 2}, {Name: "c.example.org"}}
+
+	merged := mergeHosts(existing, hosts, "test.yml")
+
+	if names := hostNames(merged); !slices.Equal(names, []string{"a.example.org", "b.example.org", "c.example.org"}) {
+		t.Errorf("unexpected merged hosts %v", names)
+	}
+	if merged[1].Timeout != 2 {
+		t.Errorf("expected the conflicting host to be replaced, got timeout %s", merged[1].Timeout)
+	}
+}
+
+func TestDedupeHosts(t *testing.T) {
+	hosts := []HostConfig{{Name: "a.example.org"}, {Name: "b.example.org"}, {Name: "a.example.org", Timeout: 1}}
+
+	deduped := dedupeHosts(hosts)
+
+	if names := hostNames(deduped); !slices.Equal(names, []string{"a.example.org", "b.example.org"}) {
+		t.Errorf("unexpected deduplicated hosts %v", names)
+	}
+	if deduped[0].Timeout != 0 {
+		t.Errorf("expected the first of the duplicates to be kept, got timeout %s", deduped[0].Timeout)
+	}
+}
+
+func TestValidateResolver(t *testing.T) {
+	tests := []struct {
+		mode    string
+		address string
+		valid   bool
+	}{
+		{mode: ModeStdlib, address: SystemResolver, valid: true},
+		{mode: ModeStdlib, address: "127.0.0.1:5353", valid: true},
+		{mode: ModeRaw, address: "[::1]:8600", valid: true},
+		{mode: ModeDoT, address: "dns.example.org:853", valid: true},
+		{mode: ModeDoH, address: "https://dns.example.org/dns-query", valid: true},
+		{mode: ModeRaw, address: "127.0.0.1"},
+		{mode: ModeRaw, address: "127.0.0.1:dns"},
+		{mode: ModeRaw, address: "127.0.0.1:0"},
+		{mode: ModeRaw, address: "127.0.0.1:65536"},
+		{mode: ModeDoT, address: SystemResolver},
+		{mode: ModeDoH, address: "dns.example.org"},
+	}
+
+	for _, test := range tests {
+		if err := validateResolver(test.mode, test.address); (err == nil) != test.valid {
+			t.Errorf("mode %s, resolver '%s': expected valid %t, got error %v", test.mode, test.address, test.valid, err)
+		}
+	}
+}
+
+func TestNetworkRecordTypes(t *testing.T) {
+	tests := []struct {
+		config      string
+		recordTypes []string
+	}{
+		{config: "hosts: [{name: example.org}]", recordTypes: []string{RecordTypeIP}},
+		{config: "hosts: [{name: example.org}]\nnetwork: ip4", recordTypes: []string{RecordTypeA}},
+		{config: "hosts: [{name: example.org}]\nnetwork: [ip, ip4, ip6]", recordTypes: []string{RecordTypeIP, RecordTypeA, RecordTypeAAAA}},
+		{config: "hosts: [{name: example.org}]\nrecord_types: [MX]", recordTypes: []string{RecordTypeMX}},
+	}
+
+	for _, test := range tests {
+		config, err := LoadConfig(writeConfigFile(t, "config.yml", test.config))
+		if err != nil {
+			t.Fatalf("config '%s': unexpected error: %s", test.config, err)
+		}
+
+		collector, err := NewDNSCollector(config)
+		if err != nil {
+			t.Fatalf("config '%s': could not create dns collector: %s", test.config, err)
+		}
+		if !slices.Equal(collector.recordTypes, test.recordTypes) {
+			t.Errorf("config '%s': expected record types %v, got %v", test.config, test.recordTypes, collector.recordTypes)
+		}
+	}
+}
+
+func TestValidateNetwork(t *testing.T) {
+	config := DefaultConfig()
+	config.Network = stringList{"ip5"}
+	if err := config.Validate(); err == nil {
+		t.Error("expected an unsupported network to be invalid")
+	}
+
+	config.Network = stringList{NetworkIP4}
+	config.RecordTypes = []string{RecordTypeA}
+	if err := config.Validate(); err == nil {
+		t.Error("expected network combined with record_types to be invalid")
+	}
+}
+
+func TestLoadConfigUnknownFields(t *testing.T) {
+	tests := []struct {
+		config string
+		field  string
+	}{
+		{config: "hots:\n  - example.org\n", field: "hots"},
+		{config: "hosts:\n  - name: example.org\n    timout: 1s\n", field: "timout"},
+		{config: "hosts:\n  - name: example.org\n    expected:\n      A:\n        value: [192.0.2.1]\n", field: "value"},
+	}
+
+	for _, test := range tests {
+		_, err := LoadConfig(writeConfigFile(t, "config.yml", test.config))
+		if err == nil || !strings.Contains(err.Error(), "field "+test.field+" not found") {
+			t.Errorf("expected an error for unknown field %s, got %v", test.field, err)
+		}
+	}
+
+	config, err := LoadConfig(writeConfigFile(t, "config.yml", "hosts:\n  - name: example.org\n    types: [A]\n"))
+	if err != nil {
+		t.Fatalf("expected the types alias to be accepted, got %s", err)
+	}
+	if !slices.Equal(config.Hosts[0].RecordTypes, []string{RecordTypeA}) {
+		t.Errorf("expected record types [A], got %v", config.Hosts[0].RecordTypes)
+	}
+}
+
+func TestValidateIgnoreErrors(t *testing.T) {
+	config := testConfig()
+	config.Hosts = []HostConfig{{Name: "missing.example.org", IgnoreErrors: []string{"nxdomian"}}}
+	if err := config.Validate(); err == nil {
+		t.Error("expected an error for an unknown error type")
+	}
+
+	config.Hosts[0].IgnoreErrors = []string{ErrorTypeNXDomain}
+	if err := config.Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	path := writeConfigFile(t, "config.yml", `
+timeout: 5s
+record_types: [A]
+defaults:
+  timeout: 2s
+  record_types: [AAAA]
+hosts:
+  - name: defaulted.example.org
+  - name: overridden.example.org
+    timeout: 1s
+    record_types: [MX]
+`)
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("could not load config: %s", err)
+	}
+
+	collector, err := NewDNSCollector(config)
+	if err != nil {
+		t.Fatalf("could not create dns collector: %s", err)
+	}
+
+	tests := []struct {
+		timeout     time.Duration
+		recordTypes []string
+	}{
+		{timeout: 2 * time.Second, recordTypes: []string{RecordTypeAAAA}},
+		{timeout: time.Second, recordTypes: []string{RecordTypeMX}},
+	}
+
+	for i, test := range tests {
+		host := config.Hosts[i]
+		if timeout := collector.hostTimeout(host); timeout != test.timeout {
+			t.Errorf("%s: expected a timeout of %s, got %s", host.Name, test.timeout, timeout)
+		}
+		if recordTypes := collector.hostRecordTypes(host); !slices.Equal(recordTypes, test.recordTypes) {
+			t.Errorf("%s: expected record types %v, got %v", host.Name, test.recordTypes, recordTypes)
+		}
+	}
+}
+
+func TestLoadConfigWithoutDefaults(t *testing.T) {
+	config, err := LoadConfig(writeConfigFile(t, "config.yml", "timeout: 3s\nhosts:\n  - example.org\n"))
+	if err != nil {
+		t.Fatalf("could not load config: %s", err)
+	}
+
+	collector, err := NewDNSCollector(config)
+	if err != nil {
+		t.Fatalf("could not create dns collector: %s", err)
+	}
+	if timeout := collector.hostTimeout(config.Hosts[0]); timeout != 3*time.Second {
+		t.Errorf("expected the global timeout of 3s, got %s", timeout)
+	}
+}
+
+func TestValidateDefaultsName(t *testing.T) {
+	config := testConfig("example.org")
+	config.Defaults.Name = "default.example.org"
+	if err := config.Validate(); err == nil {
+		t.Error("expected an error for defaults setting a host name")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net"
+	"sync"
+)
+
+const (
+	maxIdleConns = 16
+)
+
+// connPool keeps UDP connections open between lookups, so that measured
+// latency reflects the query round-trip rather than socket setup.
+type connPool struct {
+	idle      map[string][]*net.UDPConn
+	idleMutex sync.Mutex
+}
+
+func newConnPool() *connPool {
+	return &connPool{idle: map[string][]*net.UDPConn{}}
+}
+
+func (p *connPool) get(address string) *net.UDPConn {
+	p.idleMutex.Lock()
+	defer p.idleMutex.Unlock()
+
+	conns := p.idle[address]
+	if len(conns) == 0 {
+		return nil
+	}
+
+	conn := conns[len(conns)-1]
+	p.idle[address] = conns[:len(conns)-1]
+
+	return conn
+}
+
+func (p *connPool) put(address string, conn *net.UDPConn) {
+	p.idleMutex.Lock()
+	defer p.idleMutex.Unlock()
+
+	if len(p.idle[address]) >= maxIdleConns {
+		conn.Close()
+		return
+	}
+
+	p.idle[address] = append(p.idle[address], conn)
+}
+
+// pooledConn returns the underlying connection to the pool on Close. It is
+// a net.PacketConn, as otherwise the stdlib resolver frames queries as TCP.
+type pooledConn struct {
+	*net.UDPConn
+
+	pool    *connPool
+	address string
+}
+
+func (c *pooledConn) Close() error {
+	c.pool.put(c.address, c.UDPConn)
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestNetResolverReuseConnections(t *testing.T) {
+	address := startDNSServer(t, answerA("192.0.2.1"))
+
+	for _, reuse := range []bool{false, true} {
+		resolver := newNetResolver(address, resolverOptions{
+			protocol:         ProtocolUDP,
+			reuseConnections: reuse,
+			dialer:           sourceDialer{timeout: time.Second},
+		})
+
+		// The second lookup is made over the connection pooled by the first.
+		for i := 0; i < 2; i++ {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			resp, err := resolver.Lookup(ctx, Query{Host: "example.org", RecordType: RecordTypeA})
+			cancel()
+
+			if err != nil {
+				t.Fatalf("reuse %t, lookup %d: unexpected error: %s", reuse, i, err)
+			}
+			if !slices.Equal(resp.Answers, []string{"192.0.2.1"}) {
+				t.Errorf("reuse %t, lookup %d: expected answers [192.0.2.1], got %v", reuse, i, resp.Answers)
+			}
+		}
+	}
+}
+
+func TestConnPoolPutBeyondMaxIdle(t *testing.T) {
+	pool := newConnPool()
+	address := startDNSServer(t, answerA("192.0.2.1"))
+
+	dialer := sourceDialer{timeout: time.Second}
+	for i := 0; i < maxIdleConns+1; i++ {
+		conn, err := dialer.DialContext(context.Background(), "udp", address)
+		if err != nil {
+			t.Fatalf("could not dial: %s", err)
+		}
+		pool.put(address, conn.(*net.UDPConn))
+	}
+
+	if idle := len(pool.idle[address]); idle != maxIdleConns {
+		t.Errorf("expected %d idle connections, got %d", maxIdleConns, idle)
+	}
+}
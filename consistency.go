@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// consistencyKey identifies the probes whose answers are compared, which are
+// of different resolvers but the same client subnet. Only probes with the
+// default query flags are compared, as others, such as without recursion,
+// are expected to be answered differently.
+type consistencyKey struct {
+	host       string
+	recordType string
+	ecs        string
+}
+
+// consistencyRound collects the answers of every successful probe in a round.
+type consistencyRound struct {
+	answers map[consistencyKey][][]string
+	mutex   sync.Mutex
+}
+
+func newConsistencyRound() *consistencyRound {
+	return &consistencyRound{answers: map[consistencyKey][][]string{}}
+}
+
+func (r *consistencyRound) add(key probeKey, answers []string) {
+	if answers == nil || key.flags != (queryFlags{}) {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	k := consistencyKey{host: key.host, recordType: key.recordType, ecs: key.ecs}
+	r.answers[k] = append(r.answers[k], normalizeAnswers(key.recordType, answers))
+}
+
+// recordConsistency counts the hosts and record types for which resolvers
+// returned different answers in the round, regardless of their order.
+func (e *DNSCollector) recordConsistency(round *consistencyRound) {
+	if !e.checkConsistency {
+		return
+	}
+
+	e.inconsistentCountMutex.Lock()
+	defer e.inconsistentCountMutex.Unlock()
+
+	for key, answers := range round.answers {
+		for _, other := range answers[1:] {
+			if !sameAnswers(answers[0], other) {
+				e.inconsistentCount[key] += 1
+				break
+			}
+		}
+	}
+}
+
+func (e *DNSCollector) collectConsistency(ch chan<- prometheus.Metric) {
+	if !e.checkConsistency {
+		return
+	}
+
+	e.inconsistentCountMutex.Lock()
+	defer e.inconsistentCountMutex.Unlock()
+
+	for _, host := range e.Hosts() {
+		if !host.IsEnabled() {
+			continue
+		}
+
+		subnets := []string{""}
+		if len(host.ECS) > 0 {
+			subnets = host.ECS
+		}
+
+		for _, recordType := range e.hostRecordTypes(host) {
+			for _, ecs := range subnets {
+				labelValues := []string{host.Name, recordType, ecs}
+				for _, labelKey := range e.hostLabelKeys {
+					labelValues = append(labelValues, host.Labels[labelKey])
+				}
+
+				count := e.inconsistentCount[consistencyKey{host: host.Name, recordType: recordType, ecs: ecs}]
+				ch <- prometheus.MustNewConstMetric(e.inconsistent, prometheus.CounterValue, float64(count), labelValues...)
+			}
+		}
+	}
+}
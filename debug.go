@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/miekg/dns"
+)
+
+type debugResolveResult struct {
+	Host           string   `json:"host"`
+	QType          string   `json:"qtype"`
+	Resolver       string   `json:"resolver"`
+	Mode           string   `json:"mode"`
+	Source         string   `json:"source,omitempty"`
+	ECS            string   `json:"ecs,omitempty"`
+	Answers        []string `json:"answers"`
+	LatencySeconds float64  `json:"latency_seconds"`
+	Rcode          string   `json:"rcode,omitempty"`
+	Error          string   `json:"error,omitempty"`
+	ErrorType      string   `json:"error_type,omitempty"`
+}
+
+// debugResolveHandler resolves the host given in the request once, as a
+// scrape would but without recording metrics, and serves the result as JSON
+// for debugging. The host is probed with its configured settings, or the
+// defaults if it isn't configured, and the record type, resolver, mode, and
+// source default to its first. An ecs client subnet may be given, and the
+// host must be allowed by probe_allowed_targets, as for /probe. The rcode is
+// only known for resolvers that construct the DNS messages themselves.
+func debugResolveHandler(collector *DNSCollector, config Config) (http.HandlerFunc, error) {
+	allowed, err := compileTargetPatterns(config.ProbeAllowedTargets)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("host")
+		if name == "" {
+			http.Error(w, "host parameter is missing", http.StatusBadRequest)
+			return
+		}
+		if !targetAllowed(allowed, name) {
+			http.Error(w, "host is not allowed", http.StatusForbidden)
+			return
+		}
+
+		host := HostConfig{Name: name}.withDefaults(config.Defaults)
+		for _, configured := range collector.Hosts() {
+			if configured.Name == name {
+				host = configured
+				break
+			}
+		}
+
+		key := collector.probeKeys(host, collector.hostRecordTypes(host)[0], collector.hostResolverAddresses(host))[0]
+		if resolver := r.URL.Query().Get("resolver"); resolver != "" {
+			key.resolver = resolver
+		}
+		if mode := r.URL.Query().Get("mode"); mode != "" {
+			key.mode = mode
+		}
+		if qtype := r.URL.Query().Get("qtype"); qtype != "" {
+			if !supportsRecordType(key.mode, qtype) {
+				http.Error(w, fmt.Sprintf("unsupported record type '%s' in %s mode", qtype, key.mode), http.StatusBadRequest)
+				return
+			}
+			key.recordType = qtype
+		}
+		if source := r.URL.Query().Get("source"); source != "" {
+			key.source = source
+		}
+		if ecs := r.URL.Query().Get("ecs"); ecs != "" {
+			if _, _, err := net.ParseCIDR(ecs); err != nil {
+				http.Error(w, fmt.Sprintf("invalid ecs '%s': %s", ecs, err), http.StatusBadRequest)
+				return
+			}
+			key.ecs = ecs
+		}
+		if collector.resolver(key) == nil {
+			http.Error(w, fmt.Sprintf("resolver '%s' is not configured in %s mode with source '%s'", key.resolver, key.mode, key.source), http.StatusBadRequest)
+			return
+		}
+
+		probed := collector.probe(r.Context(), host, key)
+		resp, err := probed.resp, probed.err
+
+		result := debugResolveResult{
+			Host:           key.host,
+			QType:          key.recordType,
+			Resolver:       key.resolver,
+			Mode:           key.mode,
+			Source:         key.source,
+			ECS:            key.ecs,
+			Answers:        resp.Answers,
+			LatencySeconds: probed.elapsed.Seconds(),
+		}
+		if resp.Msg != nil {
+			result.Rcode = dns.RcodeToString[resp.Msg.Rcode]
+		}
+		if err != nil {
+			result.Error = err.Error()
+			result.ErrorType = classifyError(err)
+		}
+		if result.Answers == nil {
+			result.Answers = []string{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestDebugResolveHandler(t *testing.T) {
+	config := testConfig()
+	config.Mode = ModeRaw
+	config.ProbeAllowedTargets = []string{`.*\.example\.org`}
+	config.Defaults = HostConfig{RecordTypes: []string{RecordTypeTXT}}
+	config.Hosts = []HostConfig{
+		{Name: "mx.example.org", RecordTypes: []string{RecordTypeMX}, Class: "CH", DNSSEC: true, MinAnswers: 2},
+		{Name: "missing.example.org", IgnoreErrors: []string{ErrorTypeNXDomain}},
+		{Name: "stdlib.example.org", Mode: ModeStdlib},
+	}
+
+	var mutex sync.Mutex
+	queries := map[string]Query{}
+	collector := newFakeCollector(t, config, fakeResolver(func(ctx context.Context, q Query) (Response, error) {
+		mutex.Lock()
+		queries[q.Host] = q
+		mutex.Unlock()
+		if q.Host == "missing.example.org" {
+			return Response{}, &responseError{errorType: ErrorTypeNXDomain, err: &net.DNSError{Err: "no such host", IsNotFound: true}}
+		}
+		return Response{Answers: []string{"mx.example.org."}}, nil
+	}))
+
+	handler, err := debugResolveHandler(collector, config)
+	if err != nil {
+		t.Fatalf("could not create debug resolve handler: %s", err)
+	}
+
+	tests := []struct {
+		query  string
+		status int
+		result debugResolveResult
+	}{
+		{query: "", status: http.StatusBadRequest},
+		{query: "host=example.com", status: http.StatusForbidden},
+		{query: "host=stdlib.example.org&qtype=HTTPS", status: http.StatusBadRequest},
+		{query: "host=mx.example.org&resolver=192.0.2.53:53", status: http.StatusBadRequest},
+		{
+			query:  "host=mx.example.org",
+			status: http.StatusOK,
+			result: debugResolveResult{Host: "mx.example.org", QType: RecordTypeMX, Resolver: SystemResolver, Mode: ModeRaw, Answers: []string{"mx.example.org."}, ErrorType: ErrorTypeInsufficientAnswers},
+		},
+		{
+			query:  "host=mx.example.org&qtype=HTTPS",
+			status: http.StatusOK,
+			result: debugResolveResult{Host: "mx.example.org", QType: "HTTPS", Resolver: SystemResolver, Mode: ModeRaw, Answers: []string{"mx.example.org."}, ErrorType: ErrorTypeInsufficientAnswers},
+		},
+		{
+			query:  "host=missing.example.org",
+			status: http.StatusOK,
+			result: debugResolveResult{Host: "missing.example.org", QType: RecordTypeA, Resolver: SystemResolver, Mode: ModeRaw, Answers: []string{}},
+		},
+		{
+			query:  "host=other.example.org",
+			status: http.StatusOK,
+			result: debugResolveResult{Host: "other.example.org", QType: RecordTypeTXT, Resolver: SystemResolver, Mode: ModeRaw, Answers: []string{"mx.example.org."}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodGet, "/debug/resolve?"+test.query, nil))
+
+			if rec.Code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, rec.Code, rec.Body.String())
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+
+			var result debugResolveResult
+			if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+				t.Fatalf("could not decode result: %s", err)
+			}
+			if result.Host != test.result.Host || result.QType != test.result.QType || result.Resolver != test.result.Resolver || result.Mode != test.result.Mode || result.ErrorType != test.result.ErrorType {
+				t.Errorf("expected %+v, got %+v", test.result, result)
+			}
+			if !slices.Equal(result.Answers, test.result.Answers) {
+				t.Errorf("expected answers %v, got %v", test.result.Answers, result.Answers)
+			}
+		})
+	}
+
+	if q := queries["mx.example.org"]; q.Class != "CH" || !q.DNSSEC {
+		t.Errorf("expected the host's class and dnssec to be queried, got %+v", q)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	dnsMessageContentType = "application/dns-message"
+)
+
+type httpError struct {
+	err error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func (e *httpError) Unwrap() error {
+	return e.err
+}
+
+type dohResolver struct {
+	endpoint  string
+	client    *http.Client
+	userAgent string
+	headers   map[string]string
+}
+
+// newDoHResolver returns a resolver reusing connections to the endpoint, over
+// HTTP/2 where supported, keeping as many idle connections as there can be
+// concurrent probes.
+func newDoHResolver(endpoint string, options resolverOptions) *dohResolver {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = options.dialer.DialContext
+	transport.ForceAttemptHTTP2 = true
+	transport.IdleConnTimeout = options.idleConnTimeout
+	transport.MaxIdleConnsPerHost = options.maxIdleConns
+
+	return &dohResolver{
+		endpoint:  endpoint,
+		client:    &http.Client{Transport: transport},
+		userAgent: options.userAgent,
+		headers:   options.headers,
+	}
+}
+
+func (r *dohResolver) Lookup(ctx context.Context, q Query) (Response, error) {
+	if q.RecordType == RecordTypeIP {
+		return lookupBothFamilies(ctx, r, q)
+	}
+
+	query, err := newQuery(q)
+	if err != nil {
+		return Response{}, err
+	}
+	query.Id = 0
+
+	body, err := query.Pack()
+	if err != nil {
+		return Response{}, fmt.Errorf("could not pack query: %s", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return Response{}, &httpError{err: err}
+	}
+	for name, value := range r.headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("User-Agent", r.userAgent)
+	req.Header.Set("Content-Type", dnsMessageContentType)
+	req.Header.Set("Accept", dnsMessageContentType)
+
+	start := time.Now()
+	var firstByte time.Duration
+	req = req.WithContext(httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { firstByte = time.Since(start) },
+	}))
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return Response{}, &httpError{err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Response{}, &httpError{err: fmt.Errorf("unexpected status code %d", resp.StatusCode)}
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Response{}, &httpError{err: err}
+	}
+
+	msg := new(dns.Msg)
+	if err := msg.Unpack(respBody); err != nil {
+		return Response{}, fmt.Errorf("could not unpack response: %s", err)
+	}
+
+	if !matchesQuery(query, msg) {
+		return Response{}, errSpoofed
+	}
+
+	response, err := responseFromMsg(q.Host, query, msg)
+	response.QueryBytes = len(body)
+	response.ResponseBytes = len(respBody)
+	response.Server = r.endpoint
+	response.FirstByteDuration = firstByte
+	response.CaseMismatch = q.RandomizeCase && caseMismatch(query, msg)
+	return response, err
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// startDoHServer serves DNS-over-HTTPS, replying with the message returned by
+// respond for each query.
+func startDoHServer(t *testing.T, respond func(query *dns.Msg) *dns.Msg) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query := new(dns.Msg)
+		if err := query.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		msg, err := respond(query).Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", dnsMessageContentType)
+		w.Write(msg)
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+func TestDoHResolverLookup(t *testing.T) {
+	endpoint := startDoHServer(t, func(query *dns.Msg) *dns.Msg {
+		msg := new(dns.Msg)
+		msg.SetReply(query)
+		rr, _ := dns.NewRR(query.Question[0].Name + " 60 IN A 192.0.2.1")
+		msg.Answer = append(msg.Answer, rr)
+		return msg
+	})
+
+	resolver := newDoHResolver(endpoint, resolverOptions{dialer: sourceDialer{timeout: time.Second}})
+	resp, err := resolver.Lookup(context.Background(), Query{Host: "example.org", RecordType: RecordTypeA})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !slices.Equal(resp.Answers, []string{"192.0.2.1"}) {
+		t.Errorf("expected answers [192.0.2.1], got %v", resp.Answers)
+	}
+}
+
+func TestDoHResolverLookupMismatchedResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		respond func(query *dns.Msg) *dns.Msg
+	}{
+		{
+			name: "empty question",
+			respond: func(query *dns.Msg) *dns.Msg {
+				msg := new(dns.Msg)
+				msg.SetReply(query)
+				msg.Question = nil
+				return msg
+			},
+		},
+		{
+			name: "other name",
+			respond: func(query *dns.Msg) *dns.Msg {
+				msg := new(dns.Msg)
+				msg.SetReply(query)
+				msg.Question[0].Name = "example.com."
+				return msg
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			endpoint := startDoHServer(t, test.respond)
+
+			resolver := newDoHResolver(endpoint, resolverOptions{dialer: sourceDialer{timeout: time.Second}})
+			_, err := resolver.Lookup(context.Background(), Query{Host: "example.org", RecordType: RecordTypeA})
+			if !errors.Is(err, errSpoofed) {
+				t.Errorf("expected errSpoofed, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type tlsError struct {
+	err error
+}
+
+func (e *tlsError) Error() string {
+	return e.err.Error()
+}
+
+func (e *tlsError) Unwrap() error {
+	return e.err
+}
+
+// dotResolver queries a resolver over DNS-over-TLS, dialing a new connection
+// for every query so that the TLS handshake is included in the latency.
+type dotResolver struct {
+	address   string
+	dialer    contextDialer
+	tlsConfig *tls.Config
+	client    *dns.Client
+}
+
+func newDoTResolver(address string, options resolverOptions) (*dotResolver, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dotResolver{
+		address:   address,
+		dialer:    options.dialer,
+		tlsConfig: &tls.Config{ServerName: host},
+		client:    &dns.Client{Net: "tcp-tls"},
+	}, nil
+}
+
+// dial connects to the resolver and performs the TLS handshake. Only errors
+// of the handshake, such as failing to validate the certificate, are TLS
+// errors, rather than those connecting.
+func (r *dotResolver) dial(ctx context.Context) (net.Conn, error) {
+	conn, err := r.dialer.DialContext(ctx, "tcp", r.address)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, r.tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, &tlsError{err: err}
+	}
+
+	return tlsConn, nil
+}
+
+func (r *dotResolver) Lookup(ctx context.Context, q Query) (Response, error) {
+	if q.RecordType == RecordTypeIP {
+		return lookupBothFamilies(ctx, r, q)
+	}
+
+	query, err := newQuery(q)
+	if err != nil {
+		return Response{}, err
+	}
+
+	start := time.Now()
+	conn, err := r.dial(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+	defer conn.Close()
+	connected := time.Now()
+
+	msg, _, err := r.client.ExchangeWithConnContext(ctx, query, &dns.Conn{Conn: conn})
+	if err != nil {
+		return Response{}, err
+	}
+	queried := time.Now()
+
+	if !matchesQuery(query, msg) {
+		return Response{}, errSpoofed
+	}
+
+	resp, err := responseFromMsg(q.Host, query, msg)
+	resp.QueryBytes = query.Len()
+	resp.ResponseBytes = msg.Len()
+	resp.ConnectDuration = connected.Sub(start)
+	resp.QueryDuration = queried.Sub(connected)
+	resp.Server = r.address
+	resp.CaseMismatch = q.RandomizeCase && caseMismatch(query, msg)
+	return resp, err
+}
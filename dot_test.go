@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDoTResolverErrorTypes(t *testing.T) {
+	// A server whose certificate isn't trusted fails the handshake.
+	untrusted := httptest.NewTLSServer(http.NotFoundHandler())
+	defer untrusted.Close()
+
+	// A closed port refuses the connection before any handshake.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+	closed := listener.Addr().String()
+	listener.Close()
+
+	tests := []struct {
+		name      string
+		address   string
+		errorType string
+	}{
+		{name: "untrusted certificate", address: untrusted.Listener.Addr().String(), errorType: ErrorTypeTLS},
+		{name: "connection refused", address: closed, errorType: ErrorTypeUnknown},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolver, err := newDoTResolver(test.address, resolverOptions{dialer: sourceDialer{timeout: time.Second}})
+			if err != nil {
+				t.Fatalf("could not create resolver: %s", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			_, err = resolver.Lookup(ctx, Query{Host: "example.org", RecordType: RecordTypeA})
+			if errorType := classifyError(err); errorType != test.errorType {
+				t.Errorf("expected error type '%s', got '%s' for error %v", test.errorType, errorType, err)
+			}
+		})
+	}
+}
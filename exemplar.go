@@ -0,0 +1,15 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// newTraceID returns a random W3C trace ID, to correlate a probe's latency
+// exemplar with its log lines.
+func newTraceID() string {
+	var id [16]byte
+	rand.Read(id[:])
+
+	return hex.EncodeToString(id[:])
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// targetGroup is a group of targets in the Prometheus file_sd format.
+type targetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// LoadFileSD reads hosts from a Prometheus file_sd JSON file, with each
+// target given the labels of its group. Labels prefixed with '__' are
+// reserved for internal use by Prometheus, and are dropped.
+func LoadFileSD(path string) ([]HostConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read file_sd file '%s': %s", path, err)
+	}
+
+	var groups []targetGroup
+	if err := json.Unmarshal(data, &groups); err != nil {
+		return nil, fmt.Errorf("could not parse file_sd file '%s': %s", path, err)
+	}
+
+	hosts := []HostConfig{}
+	for _, group := range groups {
+		labels := map[string]string{}
+		for key, value := range group.Labels {
+			if !strings.HasPrefix(key, "__") {
+				labels[key] = value
+			}
+		}
+
+		for _, target := range group.Targets {
+			hosts = append(hosts, HostConfig{Name: target, Labels: labels})
+		}
+	}
+
+	return hosts, nil
+}
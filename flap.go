@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"slices"
+)
+
+// doubleCheck probes the key again straight after the result, counting a
+// flap if both succeeded with different answers.
+//
+// This is only a heuristic. The repeated query is usually answered from the
+// resolver's cache, so a change within that window suggests the cache entry
+// was replaced, as by a spoofed response, but it is also expected of records
+// served round-robin or by GeoDNS, whose answers legitimately vary, of
+// records expiring between the queries, and of load balanced resolvers whose
+// instances cache separately. It should only be enabled for hosts whose
+// answers are stable. Poisoning that happened before the first query, or
+// that persists, isn't detected, as both answers agree.
+func (e *DNSCollector) doubleCheck(ctx context.Context, host HostConfig, key probeKey, result probeResult) {
+	if result.err != nil {
+		return
+	}
+
+	repeated := e.probe(ctx, host, key)
+	e.retriesCountMutex.Lock()
+	e.retriesCount[key] += repeated.retries
+	e.retriesCountMutex.Unlock()
+	if repeated.err != nil {
+		return
+	}
+
+	if !slices.Equal(normalizeAnswers(key.recordType, result.resp.Answers), normalizeAnswers(key.recordType, repeated.resp.Answers)) {
+		e.flapCountMutex.Lock()
+		e.flapCount[key] += 1
+		e.flapCountMutex.Unlock()
+	}
+}
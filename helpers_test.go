@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// fakeResolver answers lookups by calling the function, standing in for the
+// network in tests of the collector.
+type fakeResolver func(ctx context.Context, q Query) (Response, error)
+
+func (f fakeResolver) Lookup(ctx context.Context, q Query) (Response, error) {
+	return f(ctx, q)
+}
+
+// answering returns a fake resolver answering every lookup with the answers.
+func answering(answers ...string) fakeResolver {
+	return func(ctx context.Context, q Query) (Response, error) {
+		return Response{Answers: answers}, nil
+	}
+}
+
+// newFakeCollector returns a collector of the config with every resolver
+// replaced by the fake.
+func newFakeCollector(t *testing.T, config Config, resolver Resolver) *DNSCollector {
+	t.Helper()
+
+	collector, err := NewDNSCollector(config)
+	if err != nil {
+		t.Fatalf("could not create dns collector: %s", err)
+	}
+
+	collector.resolversMutex.Lock()
+	for key := range collector.resolvers {
+		collector.resolvers[key] = resolver
+	}
+	collector.resolversMutex.Unlock()
+
+	return collector
+}
+
+// probeKeyOf returns the key probing the host for A records with the first
+// of its resolvers.
+func probeKeyOf(collector *DNSCollector, host HostConfig) probeKey {
+	return collector.probeKeys(host, RecordTypeA, collector.hostResolvers(host))[0]
+}
+
+// testConfig returns the default config probing only the hosts for A
+// records.
+func testConfig(hosts ...string) Config {
+	config := DefaultConfig()
+	config.RecordTypes = []string{RecordTypeA}
+	config.Hosts = nil
+	for _, host := range hosts {
+		config.Hosts = append(config.Hosts, HostConfig{Name: host})
+	}
+
+	return config
+}
+
+// gather collects the metrics of the collector, keyed by name.
+func gather(t *testing.T, collector prometheus.Collector) map[string]*dto.MetricFamily {
+	t.Helper()
+
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(collector); err != nil {
+		t.Fatalf("could not register collector: %s", err)
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("could not gather metrics: %s", err)
+	}
+
+	byName := map[string]*dto.MetricFamily{}
+	for _, family := range families {
+		byName[family.GetName()] = family
+	}
+
+	return byName
+}
+
+// findMetric returns the metric of the family with at least the labels.
+func findMetric(family *dto.MetricFamily, labels map[string]string) (*dto.Metric, bool) {
+	for _, metric := range family.GetMetric() {
+		matched := 0
+		for _, pair := range metric.GetLabel() {
+			if value, ok := labels[pair.GetName()]; ok && value == pair.GetValue() {
+				matched++
+			}
+		}
+		if matched == len(labels) {
+			return metric, true
+		}
+	}
+
+	return nil, false
+}
+
+// metricValue returns the value of the counter or gauge named with at least
+// the labels, failing the test if there is none.
+func metricValue(t *testing.T, families map[string]*dto.MetricFamily, name string, labels map[string]string) float64 {
+	t.Helper()
+
+	metric, ok := findMetric(families[name], labels)
+	if !ok {
+		t.Fatalf("no %s metric with labels %v", name, labels)
+	}
+
+	switch {
+	case metric.Counter != nil:
+		return metric.GetCounter().GetValue()
+	case metric.Gauge != nil:
+		return metric.GetGauge().GetValue()
+	default:
+		return metric.GetUntyped().GetValue()
+	}
+}
+
+// startDNSServer serves the handler over UDP and TCP on a local port,
+// returning its address. The servers are shut down when the test ends.
+func startDNSServer(t *testing.T, handler dns.HandlerFunc) string {
+	t.Helper()
+
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen on udp: %s", err)
+	}
+	address := packetConn.LocalAddr().String()
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		packetConn.Close()
+		t.Fatalf("could not listen on tcp: %s", err)
+	}
+
+	for _, server := range []*dns.Server{
+		{PacketConn: packetConn, Handler: handler},
+		{Listener: listener, Handler: handler},
+	} {
+		started := make(chan struct{})
+		server.NotifyStartedFunc = func() { close(started) }
+		go server.ActivateAndServe()
+		<-started
+		t.Cleanup(func() { server.Shutdown() })
+	}
+
+	return address
+}
+
+// answerA answers every A query with the address, and every other query
+// with no records.
+func answerA(address string) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		if r.Question[0].Qtype == dns.TypeA {
+			msg.Answer = append(msg.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP(address),
+			})
+		}
+		w.WriteMsg(msg)
+	}
+}
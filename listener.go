@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// listener serves the metrics of a subset of the hosts on an additional
+// address, backed by a collector and registry of its own.
+type listener struct {
+	config    ListenerConfig
+	collector *DNSCollector
+	server    *http.Server
+}
+
+// newListener returns a listener serving metrics under telemetryPath, created
+// with the same handler options as the main endpoint apart from the timeout.
+func newListener(config Config, listenerConfig ListenerConfig, telemetryPath string, opts promhttp.HandlerOpts, authUser, authPasswordHash string) (*listener, error) {
+	collector, err := NewDNSCollector(config.listenerConfig(listenerConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	registry := prometheus.NewRegistry()
+	if err := collector.Register(registry); err != nil {
+		return nil, err
+	}
+	registry.MustRegister(newBuildInfoCollector())
+
+	opts.Timeout = collector.scrapeTimeout + handlerTimeoutGrace
+
+	mux := http.NewServeMux()
+	mux.Handle(telemetryPath, basicAuth(promhttp.HandlerFor(registry, opts), authUser, authPasswordHash))
+	mux.HandleFunc("/healthz", healthzHandler)
+
+	return &listener{
+		config:    listenerConfig,
+		collector: collector,
+		server:    &http.Server{Addr: listenerConfig.Address, Handler: mux},
+	}, nil
+}
+
+// setHosts replaces the hosts probed by the listener with those it serves.
+func (l *listener) setHosts(hosts []HostConfig) error {
+	hosts = l.config.hostsOf(hosts)
+	if err := l.collector.AddResolvers(hosts); err != nil {
+		return err
+	}
+
+	l.collector.SetHosts(hosts)
+	return nil
+}
+
+// listenerCollectors returns the collectors backing the listeners.
+func listenerCollectors(listeners []*listener) []*DNSCollector {
+	collectors := []*DNSCollector{}
+	for _, listener := range listeners {
+		collectors = append(collectors, listener.collector)
+	}
+
+	return collectors
+}
+
+// listenerConfigs returns the configs the listeners were started with.
+func listenerConfigs(listeners []*listener) []ListenerConfig {
+	configs := []ListenerConfig{}
+	for _, listener := range listeners {
+		configs = append(configs, listener.config)
+	}
+
+	return configs
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func hostNames(hosts []HostConfig) []string {
+	names := []string{}
+	for _, host := range hosts {
+		names = append(names, host.Name)
+	}
+
+	return names
+}
+
+func TestListenerConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.DisabledMetrics = []string{"dns_exporter_resolution_total"}
+	config.WebhookURL = "http://example.org/hook"
+	config.Listeners = []ListenerConfig{{
+		Address:         ":9999",
+		Hosts:           []string{"google.com"},
+		DisabledMetrics: []string{"dns_exporter_resolution_seconds"},
+	}}
+
+	listenerConfig := config.listenerConfig(config.Listeners[0])
+
+	if names := hostNames(listenerConfig.Hosts); !slices.Equal(names, []string{"google.com"}) {
+		t.Errorf("expected hosts [google.com], got %v", names)
+	}
+	if !slices.Equal(listenerConfig.DisabledMetrics, []string{"dns_exporter_resolution_total", "dns_exporter_resolution_seconds"}) {
+		t.Errorf("unexpected disabled metrics %v", listenerConfig.DisabledMetrics)
+	}
+	if !slices.Equal(config.DisabledMetrics, []string{"dns_exporter_resolution_total"}) {
+		t.Errorf("disabled metrics of the main config were changed to %v", config.DisabledMetrics)
+	}
+	if listenerConfig.Listeners != nil {
+		t.Errorf("expected no listeners, got %v", listenerConfig.Listeners)
+	}
+	if listenerConfig.WebhookURL != config.WebhookURL {
+		t.Errorf("expected the webhook of its hosts to be kept, got '%s'", listenerConfig.WebhookURL)
+	}
+	if err := listenerConfig.Validate(); err != nil {
+		t.Errorf("unexpected invalid listener config: %s", err)
+	}
+}
+
+func TestListenerConfigNoHosts(t *testing.T) {
+	config := DefaultConfig()
+
+	listenerConfig := config.listenerConfig(ListenerConfig{Address: ":9999"})
+	if names := hostNames(listenerConfig.Hosts); len(names) != 0 {
+		t.Errorf("expected no hosts, got %v", names)
+	}
+}
+
+func TestUnlistenedHosts(t *testing.T) {
+	hosts := []HostConfig{{Name: "a.example.org"}, {Name: "b.example.org"}, {Name: "c.example.org"}}
+	listeners := []ListenerConfig{
+		{Address: ":9998"},
+		{Address: ":9999", Hosts: []string{"b.example.org"}},
+	}
+
+	if names := hostNames(unlistenedHosts(listeners, hosts)); !slices.Equal(names, []string{"a.example.org", "c.example.org"}) {
+		t.Errorf("expected hosts [a.example.org c.example.org], got %v", names)
+	}
+	if names := hostNames(unlistenedHosts(nil, hosts)); !slices.Equal(names, hostNames(hosts)) {
+		t.Errorf("expected every host without listeners, got %v", names)
+	}
+}
+
+func TestValidateListeners(t *testing.T) {
+	tests := []struct {
+		name      string
+		listeners []ListenerConfig
+		valid     bool
+	}{
+		{name: "valid", listeners: []ListenerConfig{{Address: ":9998"}, {Address: ":9999", Hosts: []string{"example.org"}}}, valid: true},
+		{name: "no address", listeners: []ListenerConfig{{}}},
+		{name: "duplicate address", listeners: []ListenerConfig{{Address: ":9999"}, {Address: ":9999"}}},
+		{name: "unconfigured host", listeners: []ListenerConfig{{Address: ":9999", Hosts: []string{"example.net"}}}},
+		{name: "host served twice", listeners: []ListenerConfig{{Address: ":9998", Hosts: []string{"example.org"}}, {Address: ":9999", Hosts: []string{"example.org"}}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Listeners = test.listeners
+
+			if err := config.Validate(); (err == nil) != test.valid {
+				t.Errorf("expected valid %t, got error %v", test.valid, err)
+			}
+		})
+	}
+}
+
+func TestListenerSetHosts(t *testing.T) {
+	config := DefaultConfig()
+	listener, err := newListener(config, ListenerConfig{Address: ":9999", Hosts: []string{"example.org"}}, "/metrics", promhttp.HandlerOpts{}, "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	hosts := append(slices.Clone(config.Hosts), HostConfig{Name: "example.net"})
+	if err := listener.setHosts(hosts); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if names := hostNames(listener.collector.Hosts()); !slices.Equal(names, []string{"example.org"}) {
+		t.Errorf("expected hosts [example.org], got %v", names)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+
+	LogLevelError = "error"
+	LogLevelWarn  = "warn"
+	LogLevelInfo  = "info"
+	LogLevelDebug = "debug"
+
+	// failureSummaryInterval is how often failed lookups are summarised, as
+	// individual failures are only logged at debug level.
+	failureSummaryInterval = time.Minute
+)
+
+var logLevels = map[string]slog.Level{
+	LogLevelError: slog.LevelError,
+	LogLevelWarn:  slog.LevelWarn,
+	LogLevelInfo:  slog.LevelInfo,
+	LogLevelDebug: slog.LevelDebug,
+}
+
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	logLevel, ok := logLevels[level]
+	if !ok {
+		return nil, fmt.Errorf("unsupported log level '%s'", level)
+	}
+	options := &slog.HandlerOptions{Level: logLevel}
+
+	switch format {
+	case LogFormatText:
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case LogFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	default:
+		return nil, fmt.Errorf("unsupported log format '%s'", format)
+	}
+}
+
+// LogFailures periodically logs a summary of the lookups that failed since
+// the last summary, by error type, until the context is cancelled.
+func (e *DNSCollector) LogFailures(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		e.failuresMutex.Lock()
+		failures := e.failures
+		e.failures = map[string]int{}
+		e.failuresMutex.Unlock()
+
+		if len(failures) == 0 {
+			continue
+		}
+
+		total := 0
+		args := []any{}
+		for _, errorType := range errorTypes {
+			if failures[errorType] > 0 {
+				total += failures[errorType]
+				args = append(args, errorType, failures[errorType])
+			}
+		}
+		slog.Warn("dns lookups failed", "failures", total, "interval", interval, slog.Group("error_types", args...))
+	}
+}
+
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
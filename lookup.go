@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// RecordTypeIP looks up both A and AAAA records together.
+	RecordTypeIP    = "IP"
+	RecordTypeA     = "A"
+	RecordTypeAAAA  = "AAAA"
+	RecordTypeCNAME = "CNAME"
+	RecordTypeMX    = "MX"
+	RecordTypeNS    = "NS"
+	RecordTypeTXT   = "TXT"
+	RecordTypeSRV   = "SRV"
+	RecordTypePTR   = "PTR"
+)
+
+var supportedRecordTypes = map[string]bool{
+	RecordTypeIP:    true,
+	RecordTypeA:     true,
+	RecordTypeAAAA:  true,
+	RecordTypeCNAME: true,
+	RecordTypeMX:    true,
+	RecordTypeNS:    true,
+	RecordTypeTXT:   true,
+	RecordTypeSRV:   true,
+	RecordTypePTR:   true,
+}
+
+const (
+	ErrorTypeTimeout  = "timeout"
+	ErrorTypeNotFound = "not_found"
+	// ErrorTypeNXDomain and ErrorTypeNoData distinguish names that don't
+	// exist from names without records of the type, which are reported
+	// alike as ErrorTypeNotFound by the stdlib resolver.
+	ErrorTypeNXDomain  = "nxdomain"
+	ErrorTypeNoData    = "nodata"
+	ErrorTypeTemporary = "temporary"
+	ErrorTypeHTTP      = "http"
+	ErrorTypeTLS       = "tls"
+	// ErrorTypeInsufficientAnswers is of lookups returning fewer answers
+	// than the host's min_answers.
+	ErrorTypeInsufficientAnswers = "insufficient_answers"
+	// ErrorTypeCNAMENoAddress is of address lookups answered with a CNAME
+	// chain not ending in an address, such as a dangling CNAME.
+	ErrorTypeCNAMENoAddress = "cname_no_address"
+	ErrorTypeUnknown        = "unknown"
+)
+
+var errorTypes = []string{
+	ErrorTypeTimeout,
+	ErrorTypeNotFound,
+	ErrorTypeNXDomain,
+	ErrorTypeNoData,
+	ErrorTypeTemporary,
+	ErrorTypeHTTP,
+	ErrorTypeTLS,
+	ErrorTypeInsufficientAnswers,
+	ErrorTypeCNAMENoAddress,
+	ErrorTypeUnknown,
+}
+
+func classifyError(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return ErrorTypeTimeout
+	}
+
+	var httpErr *httpError
+	if errors.As(err, &httpErr) {
+		return ErrorTypeHTTP
+	}
+
+	var tlsErr *tlsError
+	if errors.As(err, &tlsErr) {
+		return ErrorTypeTLS
+	}
+
+	var responseErr *responseError
+	if errors.As(err, &responseErr) {
+		return responseErr.errorType
+	}
+
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return ErrorTypeTimeout
+		}
+
+		return ErrorTypeUnknown
+	}
+
+	switch {
+	case dnsErr.IsTimeout:
+		return ErrorTypeTimeout
+	case dnsErr.IsNotFound:
+		return ErrorTypeNotFound
+	case dnsErr.IsTemporary:
+		return ErrorTypeTemporary
+	default:
+		return ErrorTypeUnknown
+	}
+}
+
+var addressPattern = regexp.MustCompile(`\[?[0-9a-fA-F]*[:.][0-9a-fA-F:.]*\]?(:[0-9]+)?`)
+
+// normalizeError returns the error message without the names and addresses
+// of the lookup, so that it can be used as a label value. DNS errors are
+// reduced to their description, such as "no such host", network errors to
+// the operation and cause, such as "dial: connection refused", and from any
+// other message the host is removed and IP addresses and ports are replaced
+// with "<address>".
+func normalizeError(host string, err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.Err
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Err != nil {
+		return opErr.Op + ": " + normalizeError(host, opErr.Err)
+	}
+
+	var syscallErr *os.SyscallError
+	if errors.As(err, &syscallErr) {
+		return syscallErr.Err.Error()
+	}
+
+	message := err.Error()
+	if host != "" {
+		message = strings.ReplaceAll(message, host, "<host>")
+	}
+	return addressPattern.ReplaceAllStringFunc(message, func(match string) string {
+		if net.ParseIP(strings.Trim(match, "[]")) == nil {
+			if h, _, err := net.SplitHostPort(match); err != nil || net.ParseIP(h) == nil {
+				return match
+			}
+		}
+		return "<address>"
+	})
+}
+
+const (
+	SystemResolver = "system"
+)
+
+const (
+	ModeStdlib = "stdlib"
+	ModeRaw    = "raw"
+	ModeDoH    = "doh"
+	ModeDoT    = "dot"
+)
+
+const (
+	ProtocolUDP   = "udp"
+	ProtocolTCP   = "tcp"
+	ProtocolHTTPS = "https"
+	ProtocolTLS   = "tls"
+)
+
+// resolverProtocol returns the transport protocol used to query resolvers in
+// the given mode, as exposed in the proto label.
+func resolverProtocol(mode, protocol string) (string, error) {
+	switch mode {
+	case ModeDoH:
+		return ProtocolHTTPS, nil
+	case ModeDoT:
+		return ProtocolTLS, nil
+	}
+
+	switch protocol {
+	case "":
+		return ProtocolUDP, nil
+	case ProtocolUDP, ProtocolTCP:
+		return protocol, nil
+	default:
+		return "", fmt.Errorf("unsupported protocol '%s'", protocol)
+	}
+}
+
+type resolverOptions struct {
+	protocol         string
+	preferGo         bool
+	reuseConnections bool
+	ednsBufferSize   uint16
+	userAgent        string
+	headers          map[string]string
+	idleConnTimeout  time.Duration
+	maxIdleConns     int
+	dialer           contextDialer
+	source           string
+}
+
+// resolverKey identifies a resolver queried in a mode from a source address.
+type resolverKey struct {
+	address string
+	mode    string
+	source  string
+}
+
+// Response is the result of a lookup. Msg is the raw DNS response, and is
+// only set by resolvers that construct the DNS messages themselves.
+// Truncated is set if a truncated UDP response was retried over TCP.
+type Response struct {
+	Answers   []string
+	SRV       []*net.SRV
+	Msg       *dns.Msg
+	Truncated bool
+
+	// QueryBytes and ResponseBytes are the sizes of the DNS messages sent and
+	// received, and are only set along with Msg.
+	QueryBytes    int
+	ResponseBytes int
+
+	// ConnectDuration and QueryDuration split the lookup into establishing
+	// the connection and exchanging the messages, and are only set by the raw
+	// and dot resolvers.
+	ConnectDuration time.Duration
+	QueryDuration   time.Duration
+	// FirstByteDuration is the time until the first byte of the response,
+	// and is only set by the doh resolver.
+	FirstByteDuration time.Duration
+
+	// CaseMismatch is set if the query name was randomized by case but not
+	// echoed in exactly the same case.
+	CaseMismatch bool
+
+	// Server is the address of the server queried, and is only set by
+	// resolvers that construct the DNS messages themselves.
+	Server string
+}
+
+// Query describes a single lookup. DNSSEC sets the DO bit for resolvers that
+// construct the DNS messages themselves.
+type Query struct {
+	Host       string
+	RecordType string
+	DNSSEC     bool
+	// Class is the query class, defaulting to IN.
+	Class string
+	// ECS is the CIDR sent as the EDNS Client Subnet, if set.
+	ECS string
+	// NSID requests the identifier of the answering server.
+	NSID bool
+	// RandomizeCase randomizes the case of the query name.
+	RandomizeCase bool
+	// NoRecursion clears the RD bit, and CheckingDisabled sets the CD bit.
+	NoRecursion      bool
+	CheckingDisabled bool
+}
+
+// Resolver performs lookups for the collector, which only depends on this
+// interface, so that a fake returning canned responses can stand in for the
+// network, such as by replacing the collector's resolvers.
+type Resolver interface {
+	Lookup(ctx context.Context, q Query) (Response, error)
+}
+
+func newResolver(mode, address string, options resolverOptions) (Resolver, error) {
+	switch mode {
+	case "", ModeStdlib:
+		return newNetResolver(address, options), nil
+	case ModeRaw:
+		return newRawResolver(address, options)
+	case ModeDoH:
+		if address == SystemResolver {
+			return nil, fmt.Errorf("%s mode requires a resolver endpoint", mode)
+		}
+		return newDoHResolver(address, options), nil
+	case ModeDoT:
+		if address == SystemResolver {
+			return nil, fmt.Errorf("%s mode requires a resolver address", mode)
+		}
+		return newDoTResolver(address, options)
+	default:
+		return nil, fmt.Errorf("unsupported mode '%s'", mode)
+	}
+}
+
+type netResolver struct {
+	resolver *net.Resolver
+}
+
+func newNetResolver(address string, options resolverOptions) *netResolver {
+	if address == SystemResolver && options.protocol == ProtocolUDP && !options.preferGo && !options.reuseConnections && options.source == "" {
+		return &netResolver{resolver: net.DefaultResolver}
+	}
+
+	var pool *connPool
+	if options.reuseConnections {
+		pool = newConnPool()
+	}
+
+	return &netResolver{
+		resolver: &net.Resolver{
+			// The Dial function is only used by the pure Go resolver, so it is
+			// always preferred when querying a specific resolver.
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, systemAddress string) (net.Conn, error) {
+				// A configured resolver is dialled at exactly the host and
+				// port given, replacing the system nameserver.
+				if address != SystemResolver {
+					systemAddress = address
+				}
+				if options.protocol == ProtocolTCP {
+					network = ProtocolTCP
+				}
+
+				if pool == nil || network != ProtocolUDP {
+					return options.dialer.DialContext(ctx, network, systemAddress)
+				}
+
+				if conn := pool.get(systemAddress); conn != nil {
+					return &pooledConn{UDPConn: conn, pool: pool, address: systemAddress}, nil
+				}
+
+				conn, err := options.dialer.DialContext(ctx, network, systemAddress)
+				if err != nil {
+					return nil, err
+				}
+				udpConn, ok := conn.(*net.UDPConn)
+				if !ok {
+					return conn, nil
+				}
+
+				return &pooledConn{UDPConn: udpConn, pool: pool, address: systemAddress}, nil
+			},
+		},
+	}
+}
+
+func (r *netResolver) Lookup(ctx context.Context, q Query) (Response, error) {
+	host, recordType := q.Host, q.RecordType
+
+	if recordType == RecordTypeSRV {
+		if err := validateSRVName(host); err != nil {
+			return Response{}, err
+		}
+
+		_, srvs, err := r.resolver.LookupSRV(ctx, "", "", host)
+		return Response{Answers: srvTargets(srvs), SRV: srvs}, err
+	}
+
+	answers, err := r.lookup(ctx, host, recordType)
+	return Response{Answers: answers}, err
+}
+
+// lookup returns any answers along with the error, as the resolver returns
+// the valid records of a response that also contained invalid ones.
+func (r *netResolver) lookup(ctx context.Context, host, recordType string) ([]string, error) {
+	resolver := r.resolver
+
+	switch recordType {
+	case RecordTypeIP:
+		return lookupIP(ctx, resolver, "ip", host)
+	case RecordTypeA:
+		return lookupIP(ctx, resolver, "ip4", host)
+	case RecordTypeAAAA:
+		return lookupIP(ctx, resolver, "ip6", host)
+	case RecordTypeCNAME:
+		cname, err := resolver.LookupCNAME(ctx, host)
+		if cname == "" {
+			return nil, err
+		}
+		return []string{cname}, err
+	case RecordTypeMX:
+		mxs, err := resolver.LookupMX(ctx, host)
+		answers := []string{}
+		for _, mx := range mxs {
+			answers = append(answers, mx.Host)
+		}
+		return answers, err
+	case RecordTypeNS:
+		nss, err := resolver.LookupNS(ctx, host)
+		answers := []string{}
+		for _, ns := range nss {
+			answers = append(answers, ns.Host)
+		}
+		return answers, err
+	case RecordTypeTXT:
+		return resolver.LookupTXT(ctx, host)
+	case RecordTypePTR:
+		return resolver.LookupAddr(ctx, host)
+	default:
+		return nil, fmt.Errorf("unsupported record type '%s'", recordType)
+	}
+}
+
+// validateSRVName checks that the name is of the form _service._proto.name.
+func validateSRVName(name string) error {
+	labels := strings.SplitN(name, ".", 3)
+	if len(labels) < 3 || !strings.HasPrefix(labels[0], "_") || !strings.HasPrefix(labels[1], "_") || labels[2] == "" {
+		return &net.DNSError{Err: "malformed SRV name", Name: name}
+	}
+
+	return nil
+}
+
+func srvTargets(srvs []*net.SRV) []string {
+	targets := []string{}
+	for _, srv := range srvs {
+		targets = append(targets, srv.Target)
+	}
+
+	return targets
+}
+
+// lookupBothFamilies looks up A and AAAA records separately, for resolvers
+// that can only query a single record type at a time.
+// The returned Msg is the A response with the AAAA answers appended.
+func lookupBothFamilies(ctx context.Context, resolver Resolver, q Query) (Response, error) {
+	ipv4Query, ipv6Query := q, q
+	ipv4Query.RecordType = RecordTypeA
+	ipv6Query.RecordType = RecordTypeAAAA
+
+	ipv4, ipv4Err := resolver.Lookup(ctx, ipv4Query)
+	ipv6, ipv6Err := resolver.Lookup(ctx, ipv6Query)
+	if ipv4Err != nil && ipv6Err != nil {
+		return Response{}, ipv4Err
+	}
+
+	msg, server := ipv4.Msg, ipv4.Server
+	if msg == nil {
+		msg, server = ipv6.Msg, ipv6.Server
+	} else if ipv6.Msg != nil {
+		msg = msg.Copy()
+		msg.Answer = append(msg.Answer, ipv6.Msg.Answer...)
+	}
+
+	return Response{
+		Answers:   append(ipv4.Answers, ipv6.Answers...),
+		Msg:       msg,
+		Truncated: ipv4.Truncated || ipv6.Truncated,
+
+		CaseMismatch: ipv4.CaseMismatch || ipv6.CaseMismatch,
+
+		QueryBytes:    ipv4.QueryBytes + ipv6.QueryBytes,
+		ResponseBytes: ipv4.ResponseBytes + ipv6.ResponseBytes,
+
+		ConnectDuration:   ipv4.ConnectDuration + ipv6.ConnectDuration,
+		QueryDuration:     ipv4.QueryDuration + ipv6.QueryDuration,
+		FirstByteDuration: ipv4.FirstByteDuration + ipv6.FirstByteDuration,
+
+		Server: server,
+	}, nil
+}
+
+func lookupIP(ctx context.Context, resolver *net.Resolver, network, host string) ([]string, error) {
+	ips, err := resolver.LookupIP(ctx, network, host)
+
+	answers := []string{}
+	for _, ip := range ips {
+		answers = append(answers, ip.String())
+	}
+
+	return answers, err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		errorType string
+	}{
+		{name: "dns timeout", err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}, errorType: ErrorTypeTimeout},
+		{name: "dns not found", err: &net.DNSError{Err: "no such host", IsNotFound: true}, errorType: ErrorTypeNotFound},
+		{name: "dns temporary", err: &net.DNSError{Err: "server misbehaving", IsTemporary: true}, errorType: ErrorTypeTemporary},
+		{name: "dns other", err: &net.DNSError{Err: "REFUSED"}, errorType: ErrorTypeUnknown},
+		{name: "wrapped dns", err: fmt.Errorf("lookup failed: %w", &net.DNSError{Err: "no such host", IsNotFound: true}), errorType: ErrorTypeNotFound},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, errorType: ErrorTypeTimeout},
+		{name: "canceled", err: context.Canceled, errorType: ErrorTypeTimeout},
+		{name: "net timeout", err: &net.OpError{Op: "read", Net: "udp", Err: os.ErrDeadlineExceeded}, errorType: ErrorTypeTimeout},
+		{name: "nxdomain", err: &responseError{errorType: ErrorTypeNXDomain, err: &net.DNSError{Err: "no such host", IsNotFound: true}}, errorType: ErrorTypeNXDomain},
+		{name: "nodata", err: &responseError{errorType: ErrorTypeNoData, err: &net.DNSError{Err: "no records of the requested type", IsNotFound: true}}, errorType: ErrorTypeNoData},
+		{name: "http", err: &httpError{err: errors.New("unexpected status code 502")}, errorType: ErrorTypeHTTP},
+		{name: "tls", err: &tlsError{err: errors.New("handshake failure")}, errorType: ErrorTypeTLS},
+		{name: "other", err: errors.New("boom"), errorType: ErrorTypeUnknown},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if errorType := classifyError(test.err); errorType != test.errorType {
+				t.Errorf("expected error type '%s', got '%s'", test.errorType, errorType)
+			}
+		})
+	}
+}
+
+// recordingDialer records the addresses dialled, failing every dial.
+type recordingDialer struct {
+	addresses chan string
+}
+
+func (d recordingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.addresses <- address
+	return nil, errors.New("dial refused by test")
+}
+
+func TestNetResolverDialsConfiguredAddress(t *testing.T) {
+	for _, protocol := range []string{ProtocolUDP, ProtocolTCP} {
+		dialer := recordingDialer{addresses: make(chan string, 100)}
+		resolver := newNetResolver("127.0.0.1:8600", resolverOptions{protocol: protocol, dialer: dialer})
+
+		resolver.Lookup(context.Background(), Query{Host: "example.org", RecordType: RecordTypeA})
+		close(dialer.addresses)
+
+		dialled := 0
+		for address := range dialer.addresses {
+			dialled++
+			if address != "127.0.0.1:8600" {
+				t.Errorf("protocol %s: expected to dial 127.0.0.1:8600, dialled %s", protocol, address)
+			}
+		}
+		if dialled == 0 {
+			t.Errorf("protocol %s: expected the resolver to be dialled", protocol)
+		}
+	}
+}
@@ -1,125 +1,2415 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand"
 	"net"
 	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/miekg/dns"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/model"
 )
 
-const (
+// Namespace and Subsystem prefix the names of every metric, and can be set
+// with flags.
+var (
 	Namespace = "dns_exporter"
+	Subsystem = ""
+)
+
+const (
+	shutdownTimeout = 30 * time.Second
+
+	// handlerTimeoutGrace is added to the scrape timeout so that timed out
+	// probes can still be reported before the handler gives up with a 503.
+	handlerTimeoutGrace = time.Second
 )
 
-type Config struct {
-	Hosts []string
+var DefaultLatencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2}
+
+type probeKey struct {
+	host       string
+	recordType string
+	resolver   string
+	mode       string
+	source     string
+	ecs        string
+	flags      queryFlags
+}
+
+type latencyHistogram struct {
+	count   uint64
+	sum     float64
+	buckets map[float64]uint64
+	// exemplar is of the most recent observation, when exemplars are enabled.
+	exemplar *prometheus.Exemplar
+}
+
+func newLatencyHistogram(upperBounds []float64) *latencyHistogram {
+	buckets := map[float64]uint64{}
+	for _, bucket := range upperBounds {
+		buckets[bucket] = 0
+	}
+
+	return &latencyHistogram{buckets: buckets}
+}
+
+func (h *latencyHistogram) observe(seconds float64) {
+	h.count++
+	h.sum += seconds
+
+	for bucket := range h.buckets {
+		if seconds <= bucket {
+			h.buckets[bucket]++
+		}
+	}
+}
+
+var probeLabels = []string{"host", "qtype", "network", "resolver", "proto", "mode", "source", "ecs", "flags"}
+
+// probeLabelNames returns the label names of per-probe metrics: the probe
+// labels, followed by the static host label keys, followed by any extra
+// metric-specific labels.
+func probeLabelNames(hostLabelKeys []string, extra ...string) []string {
+	names := append([]string{}, probeLabels...)
+	names = append(names, hostLabelKeys...)
+
+	return append(names, extra...)
+}
+
+type errorKey struct {
+	probeKey
+	errorType string
+}
+
+// latencyKey identifies a latency histogram. cacheHit and nsid are empty
+// unless their labels are enabled.
+type latencyKey struct {
+	probeKey
+	cacheHit string
+	nsid     string
 }
 
 type DNSCollector struct {
-	total      *prometheus.Desc
-	totalError *prometheus.Desc
-	latency    *prometheus.Desc
+	total               *prometheus.Desc
+	totalError          *prometheus.Desc
+	latency             *prometheus.Desc
+	records             *prometheus.Desc
+	countOK             *prometheus.Desc
+	match               *prometheus.Desc
+	missingRecords      *prometheus.Desc
+	unexpectedRecords   *prometheus.Desc
+	inCIDR              *prometheus.Desc
+	cacheHits           *prometheus.Desc
+	success             *prometheus.Desc
+	retries             *prometheus.Desc
+	flaps               *prometheus.Desc
+	recordsTruncated    *prometheus.Desc
+	suspicious          *prometheus.Desc
+	caseMismatches      *prometheus.Desc
+	ttl                 *prometheus.Desc
+	cnameDepth          *prometheus.Desc
+	answerRecords       *prometheus.Desc
+	ttlOK               *prometheus.Desc
+	rcodes              *prometheus.Desc
+	lastSuccess         *prometheus.Desc
+	consecutiveFailures *prometheus.Desc
+	truncated           *prometheus.Desc
+	slow                *prometheus.Desc
+	partial             *prometheus.Desc
+	srvRecords          *prometheus.Desc
+	srvTarget           *prometheus.Desc
+	policyPresent       *prometheus.Desc
+	policyValid         *prometheus.Desc
+	changes             *prometheus.Desc
+	dnssec              *prometheus.Desc
+	rrsigExpiry         *prometheus.Desc
+	latencyMin          *prometheus.Desc
+	latencyMax          *prometheus.Desc
+	successRatio        *prometheus.Desc
+
+	queryBytes    *prometheus.Desc
+	responseBytes *prometheus.Desc
+	queue         *prometheus.Desc
+	spoofed       *prometheus.Desc
+	nextProbeTime *prometheus.Desc
+	lastError     *prometheus.Desc
+	server        *prometheus.Desc
+	authority     *prometheus.Desc
+	connect       *prometheus.Desc
+	query         *prometheus.Desc
+	firstByte     *prometheus.Desc
+
+	inflight        *prometheus.Desc
+	stuck           *prometheus.Desc
+	configuredHosts *prometheus.Desc
+	hostEnabled     *prometheus.Desc
+	panics          *prometheus.Desc
+	inconsistent    *prometheus.Desc
+	scrapeDuration  *prometheus.Desc
+	hostsProbed     *prometheus.Desc
+	groupTotal      *prometheus.Desc
+	groupTotalError *prometheus.Desc
+	reloadSuccess   *prometheus.Desc
+	reloadTime      *prometheus.Desc
+	systemTime      *prometheus.Desc
+
+	hosts         []HostConfig
+	hostsMutex    sync.RWMutex
+	hostLabelKeys []string
+	recordTypes   []string
+	// resolvers are created for the global resolvers, and those of hosts
+	// overriding them, from each source address.
+	resolvers      map[resolverKey]Resolver
+	resolversMutex sync.RWMutex
+	// resolverAddresses and sources are the configured resolvers and source
+	// addresses in order, with the empty source for the default.
+	resolverAddresses []string
+	sources           []string
+	resolverOptions   resolverOptions
+	dialers           map[string]contextDialer
+	// resolverRotation is set when each host is probed with one resolver at
+	// a time, and rotationIndex holds each host's position in it.
+	resolverRotation   []string
+	rotationIndex      map[string]int
+	rotationIndexMutex sync.Mutex
+	// mode is the global mode, and protocol the configured transport of the
+	// stdlib and raw modes.
+	mode     string
+	protocol string
+	// compareWithSystem is set when hosts are also probed with the system
+	// resolver.
+	compareWithSystem bool
+
+	srvTargetInfo bool
+	absoluteNames bool
+	cacheHitLabel bool
+	nsid          bool
+	// caseRandomization is set when query names are randomized by case, with
+	// caseMismatchCount counting the responses not echoing them.
+	caseRandomization      bool
+	caseMismatchCount      map[probeKey]int
+	caseMismatchCountMutex sync.Mutex
+	exemplars              bool
+	class                  string
+	timeout                time.Duration
+	scrapeTimeout          time.Duration
+
+	semaphore chan struct{}
+	// queueTime is how long the most recent probe of each key waited for the
+	// semaphore.
+	queueTime      map[probeKey]time.Duration
+	queueTimeMutex sync.Mutex
+	inflightCount  atomic.Int64
+	// inflightDeadlines are when each probe in flight should have timed out
+	// by, keyed by a sequence number, to count those stuck past it.
+	inflightDeadlines      map[int64]time.Time
+	inflightDeadlinesMutex sync.Mutex
+	inflightSequence       atomic.Int64
+
+	disabledMetrics map[*prometheus.Desc]bool
+	panicsCount     atomic.Int64
+	// lastScrapeDuration is how long the most recent round of probes took,
+	// in nanoseconds.
+	lastScrapeDuration atomic.Int64
+	// lastHostsProbed is how many hosts were probed in the most recent round,
+	// not counting those whose probes started after the scrape timed out.
+	lastHostsProbed atomic.Int64
+	// lastRoundKeys are the keys of the most recent round of probes, including
+	// those not yet due, whose latest results are the current ones.
+	lastRoundKeys      map[probeKey]bool
+	lastRoundKeysMutex sync.Mutex
+	// lastReloadFailed is whether the most recent reload failed, and
+	// lastReloadTime when the config was last loaded successfully, in
+	// nanoseconds since the epoch.
+	lastReloadFailed atomic.Bool
+	lastReloadTime   atomic.Int64
+
+	probeInterval time.Duration
+	probeJitter   time.Duration
+	// maxProbeInterval is set when background probes adapt their interval to
+	// the TTL of their answers, with nextProbe holding when each is due.
+	maxProbeInterval time.Duration
+	nextProbe        map[probeKey]time.Time
+	nextProbeMutex   sync.Mutex
+	cache            map[probeKey][]prometheus.Metric
+	cacheMutex       sync.Mutex
+
+	// cacheTTL is how long probed results are reported for when probing on
+	// scrape, with scrapeCache holding them.
+	cacheTTL         time.Duration
+	scrapeCache      map[probeKey]cachedProbe
+	cacheHitsCount   map[probeKey]int
+	scrapeCacheMutex sync.Mutex
 
-	hosts []string
+	maxRetries   int
+	retryBackoff time.Duration
 
-	totalCount      map[string]int
+	totalCount      map[probeKey]int
 	totalCountMutex sync.Mutex
 
-	totalErrorCount      map[string]int
+	totalErrorCount      map[errorKey]int
 	totalErrorCountMutex sync.Mutex
+
+	// failures counts failed lookups by error type since the last summary
+	// logged by LogFailures.
+	failures      map[string]int
+	failuresMutex sync.Mutex
+
+	latencyBuckets []float64
+	latencies      map[latencyKey]*latencyHistogram
+	// latencyKeys are the keys of the latency histograms of each probe.
+	latencyKeys    map[probeKey][]latencyKey
+	latenciesMutex sync.Mutex
+	// nativeLatencies is set when latency is exposed as native histograms,
+	// in which case latencies only tracks the series to emit.
+	nativeLatencies *prometheus.HistogramVec
+
+	maxTTLs      map[probeKey]uint32
+	maxTTLsMutex sync.Mutex
+
+	latencyWindow        int
+	recentLatencies      map[probeKey]*ring
+	recentLatenciesMutex sync.Mutex
+
+	// recentSuccesses holds 1 for each recent successful resolution and 0 for
+	// each failed one.
+	successWindow        int
+	recentSuccesses      map[probeKey]*ring
+	recentSuccessesMutex sync.Mutex
+
+	retriesCount      map[probeKey]int
+	retriesCountMutex sync.Mutex
+	flapCount         map[probeKey]int
+	flapCountMutex    sync.Mutex
+	// cappedCount counts the resolutions of hosts with max_info_records
+	// returning more records than it allows info series for.
+	cappedCount      map[probeKey]int
+	cappedCountMutex sync.Mutex
+	// webhook, if configured, is notified of probes changing state.
+	webhook *webhook
+	// suspiciousCount counts the resolutions of public_only hosts returning
+	// non-public addresses.
+	suspiciousCount      map[probeKey]int
+	suspiciousCountMutex sync.Mutex
+
+	rcodeCount      map[probeKey]map[string]int
+	rcodeCountMutex sync.Mutex
+
+	lastSuccessTime      map[probeKey]time.Time
+	lastSuccessTimeMutex sync.Mutex
+
+	consecutiveFailuresCount      map[probeKey]int
+	consecutiveFailuresCountMutex sync.Mutex
+
+	truncatedCount      map[probeKey]int
+	truncatedCountMutex sync.Mutex
+
+	slowCount      map[probeKey]int
+	slowCountMutex sync.Mutex
+
+	partialCount      map[probeKey]int
+	partialCountMutex sync.Mutex
+
+	spoofedCount      map[probeKey]int
+	spoofedCountMutex sync.Mutex
+
+	lastAnswers      map[probeKey][]string
+	changesCount     map[probeKey]int
+	lastAnswersMutex sync.Mutex
+
+	// checkConsistency compares the answers of every resolver for each host
+	// and record type, counting the probe rounds in which they disagree.
+	checkConsistency       bool
+	inconsistentCount      map[consistencyKey]int
+	inconsistentCountMutex sync.Mutex
 }
 
 func NewDNSCollector(config Config) (*DNSCollector, error) {
+	return newDNSCollector(config, nil)
+}
+
+// newDNSCollector returns a collector of the config. If shared is set, the
+// collector starts with its resolvers, only creating those it lacks, so that
+// connections such as to DoH endpoints are reused, and doesn't warn again
+// about unknown disabled metrics.
+func newDNSCollector(config Config, shared *DNSCollector) (*DNSCollector, error) {
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %s", err)
+	}
+
+	recordTypes := config.RecordTypes
+	if len(recordTypes) == 0 {
+		networks := []string(config.Network)
+		if len(networks) == 0 {
+			networks = []string{NetworkIP}
+		}
+		for _, network := range networks {
+			recordType, err := networkRecordType(network)
+			if err != nil {
+				return nil, err
+			}
+			recordTypes = append(recordTypes, recordType)
+		}
+	}
+
+	mode := config.Mode
+	if mode == "" {
+		mode = ModeStdlib
+	}
+
+	options := resolverOptions{
+		preferGo:         config.PreferGo,
+		reuseConnections: config.ReuseConnections,
+		ednsBufferSize:   config.EDNSBufferSize,
+		userAgent:        config.DoHUserAgent,
+		headers:          config.DoHHeaders,
+		idleConnTimeout:  config.DoHIdleConnTimeout,
+		maxIdleConns:     config.MaxConcurrency,
+	}
+	if options.ednsBufferSize == 0 {
+		options.ednsBufferSize = DefaultEDNSBufferSize
+	}
+	if options.userAgent == "" {
+		options.userAgent = userAgent()
+	}
+	if options.idleConnTimeout == 0 {
+		options.idleConnTimeout = DefaultDoHIdleConnTimeout
+	}
+	if options.maxIdleConns <= 0 {
+		options.maxIdleConns = DefaultMaxConcurrency()
+	}
+
+	connectTimeout := config.ConnectTimeout
+	if connectTimeout == 0 {
+		connectTimeout = DefaultConnectTimeout
+	}
+
+	dialers := map[string]contextDialer{}
+	for _, source := range sourceAddresses(config) {
+		dialer, err := newDialer(config.SOCKS5Proxy, source, connectTimeout)
+		if err != nil {
+			return nil, err
+		}
+		dialers[source] = dialer
+	}
+
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	scrapeTimeout := config.ScrapeTimeout
+	if scrapeTimeout == 0 {
+		scrapeTimeout = DefaultScrapeTimeout
+	}
+
+	maxConcurrency := config.MaxConcurrency
+	if maxConcurrency <= 0 {
+		maxConcurrency = DefaultMaxConcurrency()
+	}
+
+	latencyWindow := config.LatencyWindow
+	if latencyWindow == 0 {
+		latencyWindow = DefaultLatencyWindow
+	}
+
+	successWindow := config.SuccessWindow
+	if successWindow == 0 {
+		successWindow = DefaultSuccessWindow
+	}
+
+	retryBackoff := config.RetryBackoff
+	if retryBackoff == 0 {
+		retryBackoff = DefaultRetryBackoff
+	}
+
+	webhookCooldown := config.WebhookCooldown
+	if webhookCooldown == 0 {
+		webhookCooldown = DefaultWebhookCooldown
+	}
+
+	hostLabelKeys := hostLabelKeys(config.Hosts)
+
+	latencyExtraLabels := []string{}
+	if config.CacheHitLabel {
+		latencyExtraLabels = append(latencyExtraLabels, "cache_hit")
+	}
+	if config.NSID {
+		latencyExtraLabels = append(latencyExtraLabels, "nsid")
+	}
+	latencyLabels := probeLabelNames(hostLabelKeys, latencyExtraLabels...)
+
+	latencyBuckets := config.LatencyBuckets
+	if len(latencyBuckets) == 0 {
+		latencyBuckets = DefaultLatencyBuckets
+	}
+
+	latencyHelp := "Time taken to resolve DNS."
+
+	var nativeLatencies *prometheus.HistogramVec
+	if config.NativeHistograms {
+		nativeLatencies = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:                       Namespace,
+			Subsystem:                       Subsystem,
+			Name:                            "resolution_seconds",
+			Help:                            latencyHelp,
+			Buckets:                         latencyBuckets,
+			NativeHistogramBucketFactor:     1.1,
+			NativeHistogramMaxBucketNumber:  160,
+			NativeHistogramMinResetDuration: time.Hour,
+		}, latencyLabels)
+	}
+
 	dnsCollector := &DNSCollector{
 		total: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "resolution_total"),
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_total"),
 			"Total number of DNS resolutions.",
-			[]string{"host"},
+			probeLabelNames(hostLabelKeys),
 			nil,
 		),
 		totalError: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "resolution_error_total"),
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_error_total"),
 			"Total number of DNS resolution errors.",
-			[]string{"host"},
+			probeLabelNames(hostLabelKeys, "error_type"),
 			nil,
 		),
 		latency: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "resolution_seconds"),
-			"Time taken to resolve DNS.",
-			[]string{"host"},
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_seconds"),
+			latencyHelp,
+			latencyLabels,
+			nil,
+		),
+		records: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_records"),
+			"Number of records returned by the most recent DNS resolution.",
+			probeLabelNames(hostLabelKeys, "family"),
+			nil,
+		),
+		countOK: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_count_ok"),
+			"Whether the number of records returned by the most recent DNS resolution is within the host's expected range.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		match: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_match"),
+			"Whether the most recent DNS resolution returned all expected values.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		missingRecords: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_missing_records"),
+			"Number of expected IPs missing from the most recent DNS resolution.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		unexpectedRecords: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_unexpected_records"),
+			"Number of IPs returned by the most recent DNS resolution that weren't expected.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		inCIDR: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_in_cidr"),
+			"Whether every address returned by the most recent DNS resolution is within the host's expected CIDRs.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		cacheHits: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_cache_hit_total"),
+			"Total number of scrapes reporting the cached results of a probe rather than probing again.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		success: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_success"),
+			"Whether the most recent DNS resolution succeeded.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		retries: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_retries_total"),
+			"Total number of DNS resolutions retried after a temporary error.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		flaps: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_flap_total"),
+			"Total number of DNS resolutions whose answers changed when immediately repeated.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		recordsTruncated: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_records_truncated_total"),
+			"Total number of DNS resolutions returning more records than info series are exposed for.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		suspicious: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_suspicious_total"),
+			"Total number of DNS resolutions of public only hosts returning loopback, link-local, private, or unspecified addresses.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		caseMismatches: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_case_mismatch_total"),
+			"Total number of DNS responses not echoing the case of the randomized query name.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		ttl: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_ttl_seconds"),
+			"Minimum TTL of the answers returned by the most recent DNS resolution.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		ttlOK: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_ttl_ok"),
+			"Whether the minimum TTL of the answers returned by the most recent DNS resolution was within the host's bounds.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		answerRecords: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_answer_records"),
+			"Number of records of each type in the answer section of the most recent DNS response.",
+			probeLabelNames(hostLabelKeys, "rrtype"),
+			nil,
+		),
+		cnameDepth: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_cname_depth"),
+			"Number of CNAMEs followed by the most recent DNS resolution.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		rcodes: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_rcode_total"),
+			"Total number of DNS responses by response code.",
+			probeLabelNames(hostLabelKeys, "rcode"),
+			nil,
+		),
+		lastSuccess: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_last_success_timestamp_seconds"),
+			"Unix time of the last successful DNS resolution.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		consecutiveFailures: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_consecutive_failures"),
+			"Number of DNS resolutions that have failed in a row.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		truncated: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_truncated_total"),
+			"Total number of truncated UDP responses retried over TCP.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		partial: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_partial_total"),
+			"Total number of failed DNS resolutions that still returned records.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		slow: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_slow_total"),
+			"Total number of DNS resolutions that took longer than the host's SLO threshold.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		srvRecords: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_srv_records"),
+			"Number of SRV records returned by the most recent DNS resolution.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		srvTarget: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_srv_target_info"),
+			"A metric with a constant '1' value for each SRV record returned by the most recent DNS resolution.",
+			probeLabelNames(hostLabelKeys, "target", "port", "priority", "weight"),
+			nil,
+		),
+		policyPresent: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_policy_present"),
+			"Whether the most recent DNS resolution returned a record of the host's email policy.",
+			probeLabelNames(hostLabelKeys, "policy"),
+			nil,
+		),
+		policyValid: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_policy_valid"),
+			"Whether the most recent DNS resolution returned a single well-formed record of the host's email policy.",
+			probeLabelNames(hostLabelKeys, "policy"),
+			nil,
+		),
+		changes: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_changes_total"),
+			"Total number of times the set of answers returned by DNS resolution changed.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		dnssec: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_dnssec_validated"),
+			"Whether the most recent DNS response had the Authenticated Data flag set.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		rrsigExpiry: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_rrsig_expiry_seconds"),
+			"Time the soonest expiring RRSIG in the most recent DNS response expires, in seconds since the epoch.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		latencyMin: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_latency_min_seconds"),
+			"Minimum time taken to resolve DNS over the recent resolutions.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		latencyMax: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_latency_max_seconds"),
+			"Maximum time taken to resolve DNS over the recent resolutions.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		successRatio: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_success_ratio"),
+			"Ratio of the recent DNS resolutions that succeeded.",
+			probeLabelNames(hostLabelKeys),
 			nil,
 		),
 
-		hosts: config.Hosts,
+		queryBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "query_bytes"),
+			"Size of the DNS query sent by the most recent resolution.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		responseBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "response_bytes"),
+			"Size of the DNS response received by the most recent resolution.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
 
-		totalCount:      map[string]int{},
-		totalErrorCount: map[string]int{},
-	}
+		queue: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_queue_seconds"),
+			"Time the most recent DNS resolution waited for a concurrency slot.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
 
-	return dnsCollector, nil
-}
+		server: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_server_info"),
+			"The address of the server queried by the most recent DNS resolution.",
+			probeLabelNames(hostLabelKeys, "server"),
+			nil,
+		),
+		authority: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_authority_info"),
+			"A nameserver in the authority section of the most recent DNS response.",
+			probeLabelNames(hostLabelKeys, "authority"),
+			nil,
+		),
+		lastError: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_last_error"),
+			"The normalized error of the most recent DNS resolution, empty on success.",
+			probeLabelNames(hostLabelKeys, "error"),
+			nil,
+		),
+		spoofed: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_spoofed_total"),
+			"Total number of DNS responses discarded as their ID or question didn't match the query.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		connect: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_connect_seconds"),
+			"Time the most recent DNS resolution took to connect to the resolver.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		query: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_query_seconds"),
+			"Time the most recent DNS resolution took to exchange messages with the resolver once connected.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
 
-func (e *DNSCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- e.total
-	ch <- e.totalError
-	ch <- e.latency
-}
+		nextProbeTime: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_next_probe_timestamp_seconds"),
+			"Time the next background DNS resolution is due, when adapting the probe interval to the TTL.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
+		firstByte: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "doh_ttfb_seconds"),
+			"Time until the first byte of the most recent DNS-over-HTTPS response was received.",
+			probeLabelNames(hostLabelKeys),
+			nil,
+		),
 
-func (e *DNSCollector) Collect(ch chan<- prometheus.Metric) {
-	var wg sync.WaitGroup
+		inflight: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "inflight_resolutions"),
+			"Number of DNS resolutions currently in flight.",
+			nil,
+			nil,
+		),
+		stuck: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_stuck"),
+			"Number of DNS resolutions in flight for longer than their timeout.",
+			nil,
+			nil,
+		),
+		configuredHosts: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "configured_hosts"),
+			"Number of hosts configured to be resolved.",
+			nil,
+			nil,
+		),
+		hostEnabled: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "host_enabled"),
+			"Whether the configured host is probed.",
+			append([]string{"host"}, hostLabelKeys...),
+			nil,
+		),
+		panics: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "collector_panics_total"),
+			"Total number of panics recovered while resolving a host.",
+			nil,
+			nil,
+		),
+		inconsistent: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "resolution_inconsistent_total"),
+			"Total number of probe rounds in which resolvers returned different answers for the host.",
+			append([]string{"host", "qtype", "ecs"}, hostLabelKeys...),
+			nil,
+		),
+		scrapeDuration: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "scrape_duration_seconds"),
+			"Time the most recent round of probes of every host took.",
+			nil,
+			nil,
+		),
+		groupTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "group_resolution_total"),
+			"Total number of DNS resolutions of the hosts in the group.",
+			[]string{"group"},
+			nil,
+		),
+		groupTotalError: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "group_resolution_error_total"),
+			"Total number of DNS resolution errors of the hosts in the group.",
+			[]string{"group"},
+			nil,
+		),
+		hostsProbed: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "hosts_probed"),
+			"Number of hosts probed in the most recent round of probes.",
+			nil,
+			nil,
+		),
+		reloadSuccess: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "config_last_reload_successful"),
+			"Whether the last config reload attempt was successful.",
+			nil,
+			nil,
+		),
+		reloadTime: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "config_last_reload_success_timestamp_seconds"),
+			"Timestamp of the last successful config load or reload.",
+			nil,
+			nil,
+		),
+		systemTime: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "system_time_seconds"),
+			"System time of the exporter when scraped, to detect drift of its clock affecting absolute timestamps.",
+			nil,
+			nil,
+		),
+
+		hosts:         dedupeHosts(config.Hosts),
+		hostLabelKeys: hostLabelKeys,
+		recordTypes:   recordTypes,
+		resolvers:     map[resolverKey]Resolver{},
+
+		resolverAddresses: resolverAddresses(config),
+		sources:           sourceAddresses(config),
+		resolverOptions:   options,
+		dialers:           dialers,
+
+		resolverRotation: resolverRotation(config),
+		rotationIndex:    map[string]int{},
+		mode:             mode,
+		protocol:         config.Protocol,
+
+		compareWithSystem: config.CompareWithSystem,
+
+		srvTargetInfo: config.SRVTargetInfo,
+		absoluteNames: config.AbsoluteNames,
+		cacheHitLabel: config.CacheHitLabel,
+		nsid:          config.NSID,
+
+		caseRandomization: config.CaseRandomization,
+		caseMismatchCount: map[probeKey]int{},
+		exemplars:         config.Exemplars,
+		class:             config.Class,
+		timeout:           timeout,
+		scrapeTimeout:     scrapeTimeout,
 
-	wg.Add(len(e.hosts))
+		semaphore: make(chan struct{}, maxConcurrency),
+		queueTime: map[probeKey]time.Duration{},
 
-	for _, host := range e.hosts {
-		go func(host string) {
-			defer wg.Done()
+		inflightDeadlines: map[int64]time.Time{},
 
-			e.resolveHost(ch, host)
-		}(host)
+		probeInterval: config.ProbeInterval,
+		probeJitter:   config.ProbeJitter,
+
+		maxProbeInterval: config.MaxProbeInterval,
+		nextProbe:        map[probeKey]time.Time{},
+		cache:            map[probeKey][]prometheus.Metric{},
+
+		cacheTTL:       config.CacheTTL,
+		scrapeCache:    map[probeKey]cachedProbe{},
+		cacheHitsCount: map[probeKey]int{},
+
+		maxRetries:   config.Retries,
+		retryBackoff: retryBackoff,
+
+		totalCount:      map[probeKey]int{},
+		totalErrorCount: map[errorKey]int{},
+		failures:        map[string]int{},
+		latencyBuckets:  latencyBuckets,
+		latencies:       map[latencyKey]*latencyHistogram{},
+		latencyKeys:     map[probeKey][]latencyKey{},
+		maxTTLs:         map[probeKey]uint32{},
+		nativeLatencies: nativeLatencies,
+		latencyWindow:   latencyWindow,
+		recentLatencies: map[probeKey]*ring{},
+		successWindow:   successWindow,
+		recentSuccesses: map[probeKey]*ring{},
+		retriesCount:    map[probeKey]int{},
+		flapCount:       map[probeKey]int{},
+		cappedCount:     map[probeKey]int{},
+		suspiciousCount: map[probeKey]int{},
+		rcodeCount:      map[probeKey]map[string]int{},
+		lastSuccessTime: map[probeKey]time.Time{},
+
+		consecutiveFailuresCount: map[probeKey]int{},
+		truncatedCount:           map[probeKey]int{},
+		slowCount:                map[probeKey]int{},
+		partialCount:             map[probeKey]int{},
+		spoofedCount:             map[probeKey]int{},
+		lastAnswers:              map[probeKey][]string{},
+		changesCount:             map[probeKey]int{},
+
+		checkConsistency:  config.CheckConsistency,
+		inconsistentCount: map[consistencyKey]int{},
 	}
 
-	wg.Wait()
-}
+	if shared != nil {
+		shared.resolversMutex.RLock()
+		for key, resolver := range shared.resolvers {
+			dnsCollector.resolvers[key] = resolver
+		}
+		shared.resolversMutex.RUnlock()
+	}
+	if err := dnsCollector.AddResolvers(config.Hosts); err != nil {
+		return nil, err
+	}
+	dnsCollector.lastReloadTime.Store(time.Now().UnixNano())
 
-func (e *DNSCollector) resolveHost(ch chan<- prometheus.Metric, host string) {
-	start := time.Now()
+	if config.WebhookURL != "" {
+		dnsCollector.webhook = newWebhook(config.WebhookURL, webhookCooldown)
+	}
 
-	_, err := net.LookupHost(host)
-	if err != nil {
-		e.totalErrorCountMutex.Lock()
-		e.totalErrorCount[host] += 1
-		e.totalErrorCountMutex.Unlock()
+	var unknown []string
+	dnsCollector.disabledMetrics, unknown = disabledMetrics(dnsCollector.descs(), config.DisabledMetrics)
+	if shared == nil {
+		for _, name := range unknown {
+			slog.Warn("ignoring unknown disabled metric", "metric", name)
+		}
+	}
+	if dnsCollector.disabledMetrics[dnsCollector.latency] {
+		dnsCollector.nativeLatencies = nil
+	}
 
-		log.Printf("could not lookup host '%s': %s", host, err)
+	if config.MaxSeries > 0 {
+		if series := dnsCollector.estimatedSeries(); series > config.MaxSeries {
+			return nil, fmt.Errorf("an estimated %d series would be exposed, more than max_series of %d", series, config.MaxSeries)
+		}
 	}
 
-	elapsed := time.Since(start)
+	return dnsCollector, nil
+}
 
-	e.totalCountMutex.Lock()
-	e.totalCount[host] += 1
-	e.totalCountMutex.Unlock()
+// disabledMetrics returns the descriptors of the named metrics, and the names
+// that are not exposed by the collector.
+func disabledMetrics(descs []*prometheus.Desc, names []string) (map[*prometheus.Desc]bool, []string) {
+	byName := map[string]*prometheus.Desc{}
+	for _, desc := range descs {
+		byName[descName(desc)] = desc
+	}
+
+	disabled := map[*prometheus.Desc]bool{}
+	unknown := []string{}
+	for _, name := range names {
+		desc, ok := byName[name]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		disabled[desc] = true
+	}
 
-	ch <- prometheus.MustNewConstMetric(e.total, prometheus.CounterValue, float64(e.totalCount[host]), host)
-	ch <- prometheus.MustNewConstMetric(e.totalError, prometheus.CounterValue, float64(e.totalErrorCount[host]), host)
-	ch <- prometheus.MustNewConstMetric(e.latency, prometheus.GaugeValue, elapsed.Seconds(), host)
+	return disabled, unknown
 }
 
-func main() {
-	dnsCollector, err := NewDNSCollector(Config{
-		Hosts: []string{
-			"example.org",
-			"google.com",
-		},
-	})
+// descName returns the fully-qualified name of the descriptor, which is only
+// exposed through its string representation.
+func descName(desc *prometheus.Desc) string {
+	s := strings.TrimPrefix(desc.String(), "Desc{fqName: ")
+	name, err := strconv.QuotedPrefix(s)
 	if err != nil {
-		log.Fatalf("could not create dns collector: %s", err)
+		return ""
 	}
 
-	prometheus.MustRegister(dnsCollector)
+	name, _ = strconv.Unquote(name)
+	return name
+}
+
+// descs returns the descriptors of every metric the collector can expose.
+func (e *DNSCollector) descs() []*prometheus.Desc {
+	return append(e.probeDescs(),
+		e.inflight,
+		e.stuck,
+		e.configuredHosts,
+		e.hostEnabled,
+		e.panics,
+		e.inconsistent,
+		e.scrapeDuration,
+		e.hostsProbed,
+		e.groupTotal,
+		e.groupTotalError,
+		e.reloadSuccess,
+		e.reloadTime,
+		e.systemTime,
+	)
+}
+
+// probeDescs returns the descriptors of the metrics exposed for every probe.
+func (e *DNSCollector) probeDescs() []*prometheus.Desc {
+	return []*prometheus.Desc{
+		e.total,
+		e.totalError,
+		e.latency,
+		e.records,
+		e.countOK,
+		e.match,
+		e.missingRecords,
+		e.unexpectedRecords,
+		e.inCIDR,
+		e.cacheHits,
+		e.success,
+		e.retries,
+		e.flaps,
+		e.recordsTruncated,
+		e.suspicious,
+		e.caseMismatches,
+		e.ttl,
+		e.cnameDepth,
+		e.answerRecords,
+		e.ttlOK,
+		e.rcodes,
+		e.lastSuccess,
+		e.consecutiveFailures,
+		e.truncated,
+		e.slow,
+		e.partial,
+		e.srvRecords,
+		e.srvTarget,
+		e.policyPresent,
+		e.policyValid,
+		e.changes,
+		e.dnssec,
+		e.rrsigExpiry,
+		e.latencyMin,
+		e.latencyMax,
+		e.successRatio,
+		e.queryBytes,
+		e.responseBytes,
+		e.queue,
+		e.spoofed,
+		e.lastError,
+		e.server,
+		e.authority,
+		e.connect,
+		e.query,
+		e.firstByte,
+		e.nextProbeTime,
+	}
+}
+
+// estimatedSeries estimates the number of series exposed, counting the error
+// types and histogram buckets of each probe, but only one series for metrics
+// with a series per returned record or rcode, and for those only exposed for
+// some record types or modes.
+func (e *DNSCollector) estimatedSeries() int {
+	probes := 0
+	for _, host := range e.Hosts() {
+		if host.IsEnabled() {
+			resolvers := len(e.resolverAddresses)
+			if host.Resolver != "" {
+				resolvers = 1
+			}
+			if e.compareWithSystem {
+				resolvers++
+			}
+			probes += len(e.hostRecordTypes(host)) * resolvers * len(e.sources) * max(1, len(host.ECS)) * len(host.queryFlags())
+		}
+	}
+
+	perProbe := 0
+	for _, desc := range e.probeDescs() {
+		if e.disabledMetrics[desc] {
+			continue
+		}
+
+		switch desc {
+		case e.totalError:
+			perProbe += len(errorTypes)
+		case e.latency:
+			histogram := len(e.latencyBuckets) + 3
+			if e.cacheHitLabel {
+				histogram *= 2
+			}
+			if e.nsid {
+				histogram *= maxNSIDs
+			}
+			perProbe += histogram
+		default:
+			perProbe++
+		}
+	}
+
+	series := probes*perProbe + len(e.descs()) - len(e.probeDescs())
+	if e.checkConsistency && !e.disabledMetrics[e.inconsistent] {
+		for _, host := range e.Hosts() {
+			if host.IsEnabled() {
+				series += len(e.hostRecordTypes(host))
+			}
+		}
+	}
+
+	return series
+}
+
+func (e *DNSCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range e.descs() {
+		if !e.disabledMetrics[desc] {
+			ch <- desc
+		}
+	}
+}
+
+// Register registers the collector with r, replacing any DNSCollector
+// already registered, such as when the collector is rebuilt on reload.
+func (e *DNSCollector) Register(r prometheus.Registerer) error {
+	err := r.Register(e)
+
+	var registered prometheus.AlreadyRegisteredError
+	if !errors.As(err, &registered) {
+		return err
+	}
+	if registered.ExistingCollector == e {
+		return nil
+	}
+	if _, ok := registered.ExistingCollector.(*DNSCollector); !ok {
+		return err
+	}
+
+	r.Unregister(registered.ExistingCollector)
+	return r.Register(e)
+}
+
+func (e *DNSCollector) Hosts() []HostConfig {
+	e.hostsMutex.RLock()
+	defer e.hostsMutex.RUnlock()
+
+	return e.hosts
+}
+
+// SetHosts replaces the hosts probed by subsequent scrapes. Counters are
+// kept for hosts that remain. As the label names of metrics are fixed, host
+// labels not present when the collector was created are dropped.
+func (e *DNSCollector) SetHosts(hosts []HostConfig) {
+	hosts = dedupeHosts(hosts)
+
+	e.hostsMutex.Lock()
+	defer e.hostsMutex.Unlock()
+
+	e.hosts = hosts
+}
+
+// Collect probes every enabled host and record type exactly once, so
+// resolution_total counts probe attempts and grows by one per scrape.
+// Probes still running when the scrape timeout expires are recorded as errors.
+// Each probe sends its metrics as soon as it completes, so slow hosts don't
+// hold back the metrics of fast ones, and the scrape is bounded by the
+// timeouts of the slowest probes rather than their sum. At most
+// max_concurrency probes run at once, across all concurrent scrapes. When
+// probing in the background, Collect instead reports the latest results.
+func (e *DNSCollector) Collect(ch chan<- prometheus.Metric) {
+	if len(e.disabledMetrics) > 0 {
+		filtered := make(chan prometheus.Metric)
+		done := make(chan struct{})
+		go func(ch chan<- prometheus.Metric) {
+			defer close(done)
+			for metric := range filtered {
+				if !e.disabledMetrics[metric.Desc()] {
+					ch <- metric
+				}
+			}
+		}(ch)
+		defer func() {
+			close(filtered)
+			<-done
+		}()
+		ch = filtered
+	}
+
+	ch <- prometheus.MustNewConstMetric(e.inflight, prometheus.GaugeValue, float64(e.inflightCount.Load()))
+	ch <- prometheus.MustNewConstMetric(e.stuck, prometheus.GaugeValue, float64(e.stuckCount()))
+	hosts := e.Hosts()
+	ch <- prometheus.MustNewConstMetric(e.configuredHosts, prometheus.GaugeValue, float64(len(hosts)))
+	ch <- prometheus.MustNewConstMetric(e.reloadSuccess, prometheus.GaugeValue, boolToFloat64(!e.lastReloadFailed.Load()))
+	ch <- prometheus.MustNewConstMetric(e.reloadTime, prometheus.GaugeValue, float64(e.lastReloadTime.Load())/float64(time.Second))
+	ch <- prometheus.MustNewConstMetric(e.systemTime, prometheus.GaugeValue, float64(time.Now().UnixNano())/float64(time.Second))
+	for _, host := range hosts {
+		labelValues := []string{host.Name}
+		for _, labelKey := range e.hostLabelKeys {
+			labelValues = append(labelValues, host.Labels[labelKey])
+		}
+		ch <- prometheus.MustNewConstMetric(e.hostEnabled, prometheus.GaugeValue, boolToFloat64(host.IsEnabled()), labelValues...)
+	}
+	defer func() {
+		ch <- prometheus.MustNewConstMetric(e.panics, prometheus.CounterValue, float64(e.panicsCount.Load()))
+		ch <- prometheus.MustNewConstMetric(e.scrapeDuration, prometheus.GaugeValue, time.Duration(e.lastScrapeDuration.Load()).Seconds())
+		ch <- prometheus.MustNewConstMetric(e.hostsProbed, prometheus.GaugeValue, float64(e.lastHostsProbed.Load()))
+		e.collectGroups(ch)
+	}()
+
+	if e.probeInterval > 0 {
+		e.collectCached(ch)
+		e.collectConsistency(ch)
+		return
+	}
+
+	// When rotating resolvers, those not probed in this scrape report their
+	// previous results, so that their series don't disappear.
+	if e.resolverRotation != nil {
+		e.probeCached(context.Background(), 0)
+		e.collectCached(ch)
+		e.collectConsistency(ch)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.scrapeTimeout)
+	defer cancel()
+
+	round := newConsistencyRound()
+	e.forEachProbe(ctx, 0, func(host HostConfig, key probeKey) {
+		if e.cacheTTL > 0 {
+			e.resolveHostCached(ctx, ch, host, key, round)
+			return
+		}
+		round.add(key, e.resolveHost(ctx, ch, host, key))
+	})
+	e.recordConsistency(round)
+	e.collectConsistency(ch)
+}
+
+// forEachProbe calls probe concurrently for every host, record type, and
+// resolver, bounded by the semaphore, and waits for them all to return. The
+// probes of each host are delayed by a random duration up to jitter.
+func (e *DNSCollector) forEachProbe(ctx context.Context, jitter time.Duration, probe func(host HostConfig, key probeKey)) {
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	probed := map[string]bool{}
+	var probedMutex sync.Mutex
+
+	hosts := e.Hosts()
+	// Every metric of a probe is labelled by its key, so each key is only
+	// probed once, even if configured twice, such as by repeating a record
+	// type or resolver, so that no series is sent twice.
+	scheduled := map[probeKey]bool{}
+	round := map[probeKey]bool{}
+	defer func() {
+		e.lastScrapeDuration.Store(int64(time.Since(start)))
+		e.lastHostsProbed.Store(int64(len(probed)))
+
+		e.lastRoundKeysMutex.Lock()
+		e.lastRoundKeys = round
+		e.lastRoundKeysMutex.Unlock()
+	}()
+
+	for _, host := range hosts {
+		if !host.IsEnabled() {
+			continue
+		}
+
+		var delay time.Duration
+		if jitter > 0 {
+			delay = time.Duration(rand.Int63n(int64(jitter)))
+		}
+
+		resolvers := e.hostResolvers(host)
+		for _, recordType := range e.hostRecordTypes(host) {
+			for _, key := range e.probeKeys(host, recordType, resolvers) {
+				round[key] = true
+				if scheduled[key] || !e.probeDue(key) {
+					continue
+				}
+				scheduled[key] = true
+
+				wg.Add(1)
+				go func(host HostConfig, key probeKey) {
+					defer wg.Done()
+
+					if delay > 0 {
+						select {
+						case <-ctx.Done():
+						case <-time.After(delay):
+						}
+					}
+
+					// A probe still queued when the context is done goes
+					// ahead without a slot, failing as a timeout.
+					queued := time.Now()
+					select {
+					case e.semaphore <- struct{}{}:
+						defer func() { <-e.semaphore }()
+					case <-ctx.Done():
+					}
+
+					e.queueTimeMutex.Lock()
+					e.queueTime[key] = time.Since(queued)
+					e.queueTimeMutex.Unlock()
+
+					if ctx.Err() == nil {
+						probedMutex.Lock()
+						probed[host.Name] = true
+						probedMutex.Unlock()
+					}
+
+					probe(host, key)
+				}(host, key)
+			}
+		}
+	}
+
+	wg.Wait()
+}
+
+// probeResult is the outcome of probing a key once.
+type probeResult struct {
+	resp    Response
+	err     error
+	retries int
+	elapsed time.Duration
+	traceID string
+}
+
+// resolveHost probes the key, sending its metrics to ch, and returns the
+// answers when the lookup succeeded.
+func (e *DNSCollector) resolveHost(ctx context.Context, ch chan<- prometheus.Metric, host HostConfig, key probeKey) []string {
+	e.inflightCount.Add(1)
+	defer e.inflightCount.Add(-1)
+
+	defer func() {
+		if r := recover(); r != nil {
+			e.panicsCount.Add(1)
+			slog.Error("recovered panic while resolving host", "host", key.host, "qtype", key.recordType, "resolver", key.resolver, "panic", r)
+		}
+	}()
+
+	result := e.probe(ctx, host, key)
+	if host.DoubleCheck {
+		e.doubleCheck(ctx, host, key, result)
+	}
+
+	return e.emit(ch, host, key, result)
+}
+
+// probe looks up the key as a scrape does, within the host's timeout and
+// with its retries, required answers, and ignored errors, without recording
+// or sending any metrics, so that it can also be used to resolve hosts
+// outside of scrapes.
+func (e *DNSCollector) probe(ctx context.Context, host HostConfig, key probeKey) probeResult {
+	ctx, cancel := context.WithTimeout(ctx, e.hostTimeout(host))
+	defer cancel()
+
+	sequence := e.inflightSequence.Add(1)
+	e.inflightDeadlinesMutex.Lock()
+	e.inflightDeadlines[sequence] = time.Now().Add(e.hostTimeout(host))
+	e.inflightDeadlinesMutex.Unlock()
+	defer func() {
+		e.inflightDeadlinesMutex.Lock()
+		delete(e.inflightDeadlines, sequence)
+		e.inflightDeadlinesMutex.Unlock()
+	}()
+
+	var traceID string
+	if e.exemplars {
+		traceID = newTraceID()
+	}
+
+	start := time.Now()
+
+	// A probe whose context is already done, such as one queued beyond the
+	// scrape timeout, isn't looked up.
+	var resp Response
+	var retries int
+	var err error
+	if err = ctx.Err(); err == nil {
+		resp, retries, err = e.lookup(ctx, host, key)
+	}
+	if minAnswers := max(host.MinAnswers, 1); err == nil && len(resp.Answers) < minAnswers {
+		err = &responseError{
+			errorType: ErrorTypeInsufficientAnswers,
+			err:       errors.New("fewer answers returned than required"),
+		}
+	}
+	if err != nil && slices.Contains(host.IgnoreErrors, classifyError(err)) {
+		err = nil
+	}
+
+	return probeResult{resp: resp, err: err, retries: retries, elapsed: time.Since(start), traceID: traceID}
+}
+
+// emit records the result of probing the key and sends its metrics to ch,
+// returning the answers when the lookup succeeded.
+func (e *DNSCollector) emit(ch chan<- prometheus.Metric, host HostConfig, key probeKey, result probeResult) []string {
+	resp, err, elapsed, traceID := result.resp, result.err, result.elapsed, result.traceID
+	answers := resp.Answers
+	if err != nil {
+		e.totalErrorCountMutex.Lock()
+		e.totalErrorCount[errorKey{probeKey: key, errorType: classifyError(err)}] += 1
+		e.totalErrorCountMutex.Unlock()
+
+		e.failuresMutex.Lock()
+		e.failures[classifyError(err)] += 1
+		e.failuresMutex.Unlock()
+
+		slog.Debug("dns lookup failed", "host", key.host, "qtype", key.recordType, "resolver", key.resolver, "proto", e.keyProtocol(key), "mode", key.mode, "source", key.source, "ecs", key.ecs, "flags", key.flags.String(), "error_type", classifyError(err), "duration", elapsed, "trace_id", traceID, "err", err)
+	}
+
+	// Counts are read under the same lock as they are incremented, as other
+	// probes of the same key may run concurrently.
+	e.totalCountMutex.Lock()
+	e.totalCount[key] += 1
+	total := e.totalCount[key]
+	e.totalCountMutex.Unlock()
+
+	e.totalErrorCountMutex.Lock()
+	totalErrors := map[string]int{}
+	for _, errorType := range errorTypes {
+		totalErrors[errorType] = e.totalErrorCount[errorKey{probeKey: key, errorType: errorType}]
+	}
+	e.totalErrorCountMutex.Unlock()
+
+	e.retriesCountMutex.Lock()
+	e.retriesCount[key] += result.retries
+	retries := e.retriesCount[key]
+	e.retriesCountMutex.Unlock()
+
+	observed := latencyKey{probeKey: key}
+	if e.cacheHitLabel {
+		observed.cacheHit = strconv.FormatBool(e.cacheHit(key, resp.Msg))
+	}
+	if e.nsid {
+		observed.nsid = nsid(resp.Msg)
+	}
+
+	e.latenciesMutex.Lock()
+	if _, ok := e.latencies[observed]; !ok && observed.nsid != "" && e.nsidCount(key) >= maxNSIDs {
+		observed.nsid = ""
+	}
+	if _, ok := e.latencies[observed]; !ok {
+		e.latencies[observed] = newLatencyHistogram(e.latencyBuckets)
+		e.latencyKeys[key] = append(e.latencyKeys[key], observed)
+	}
+	e.latencies[observed].observe(elapsed.Seconds())
+	if e.exemplars {
+		e.latencies[observed].exemplar = &prometheus.Exemplar{
+			Value:     elapsed.Seconds(),
+			Labels:    prometheus.Labels{"trace_id": traceID},
+			Timestamp: time.Now(),
+		}
+	}
+	latencies := []prometheus.Metric{}
+	for _, series := range e.latencyKeys[key] {
+		histogram := e.latencies[series]
+		labelValues := e.labelValues(host, key)
+		if e.cacheHitLabel {
+			labelValues = append(labelValues, series.cacheHit)
+		}
+		if e.nsid {
+			labelValues = append(labelValues, series.nsid)
+		}
+
+		if e.nativeLatencies != nil {
+			native := e.nativeLatencies.WithLabelValues(labelValues...).(prometheus.Histogram)
+			if series == observed {
+				if e.exemplars {
+					native.(prometheus.ExemplarObserver).ObserveWithExemplar(elapsed.Seconds(), prometheus.Labels{"trace_id": traceID})
+				} else {
+					native.Observe(elapsed.Seconds())
+				}
+			}
+			latencies = append(latencies, native)
+			continue
+		}
+		latency := prometheus.MustNewConstHistogram(e.latency, histogram.count, histogram.sum, copyBuckets(histogram.buckets), labelValues...)
+		if histogram.exemplar != nil {
+			latency = prometheus.MustNewMetricWithExemplars(latency, *histogram.exemplar)
+		}
+		latencies = append(latencies, latency)
+	}
+	e.latenciesMutex.Unlock()
+
+	e.recentLatenciesMutex.Lock()
+	if _, ok := e.recentLatencies[key]; !ok {
+		e.recentLatencies[key] = newRing(e.latencyWindow)
+	}
+	e.recentLatencies[key].add(elapsed.Seconds())
+	latencyMin, latencyMax, _ := e.recentLatencies[key].minMax()
+	e.recentLatenciesMutex.Unlock()
+	e.queueTimeMutex.Lock()
+	queueTime := e.queueTime[key]
+	e.queueTimeMutex.Unlock()
+	ch <- prometheus.MustNewConstMetric(e.queue, prometheus.GaugeValue, queueTime.Seconds(), e.labelValues(host, key)...)
+
+	ch <- prometheus.MustNewConstMetric(e.latencyMin, prometheus.GaugeValue, latencyMin, e.labelValues(host, key)...)
+	ch <- prometheus.MustNewConstMetric(e.latencyMax, prometheus.GaugeValue, latencyMax, e.labelValues(host, key)...)
+
+	e.recentSuccessesMutex.Lock()
+	if _, ok := e.recentSuccesses[key]; !ok {
+		e.recentSuccesses[key] = newRing(e.successWindow)
+	}
+	e.recentSuccesses[key].add(boolToFloat64(err == nil))
+	successRatio, _ := e.recentSuccesses[key].mean()
+	e.recentSuccessesMutex.Unlock()
+	if e.webhook != nil {
+		e.webhook.observe(key, err)
+	}
+	ch <- prometheus.MustNewConstMetric(e.successRatio, prometheus.GaugeValue, successRatio, e.labelValues(host, key)...)
+
+	ch <- prometheus.MustNewConstMetric(e.total, prometheus.CounterValue, float64(total), e.labelValues(host, key)...)
+	for _, errorType := range errorTypes {
+		ch <- prometheus.MustNewConstMetric(e.totalError, prometheus.CounterValue, float64(totalErrors[errorType]), e.labelValues(host, key, errorType)...)
+	}
+	for _, latency := range latencies {
+		ch <- latency
+	}
+	counts := countByFamily(answers)
+	for _, family := range recordTypeFamilies(key.recordType) {
+		ch <- prometheus.MustNewConstMetric(e.records, prometheus.GaugeValue, float64(counts[family]), e.labelValues(host, key, family)...)
+	}
+	ch <- prometheus.MustNewConstMetric(e.countOK, prometheus.GaugeValue, boolToFloat64(recordCountOK(host, len(answers))), e.labelValues(host, key)...)
+	ch <- prometheus.MustNewConstMetric(e.success, prometheus.GaugeValue, boolToFloat64(err == nil), e.labelValues(host, key)...)
+	ch <- prometheus.MustNewConstMetric(e.lastError, prometheus.GaugeValue, 1, e.labelValues(host, key, normalizeError(key.host, err))...)
+	ch <- prometheus.MustNewConstMetric(e.retries, prometheus.CounterValue, float64(retries), e.labelValues(host, key)...)
+	if host.PublicOnly {
+		e.suspiciousCountMutex.Lock()
+		if hasNonPublicIP(answers) {
+			e.suspiciousCount[key] += 1
+			slog.Warn("public only host resolved to a non-public address", "host", key.host, "qtype", key.recordType, "resolver", key.resolver, "answers", answers)
+		}
+		suspicious := e.suspiciousCount[key]
+		e.suspiciousCountMutex.Unlock()
+
+		ch <- prometheus.MustNewConstMetric(e.suspicious, prometheus.CounterValue, float64(suspicious), e.labelValues(host, key)...)
+	}
+	if e.caseRandomization {
+		e.caseMismatchCountMutex.Lock()
+		if resp.CaseMismatch {
+			e.caseMismatchCount[key] += 1
+		}
+		caseMismatches := e.caseMismatchCount[key]
+		e.caseMismatchCountMutex.Unlock()
+
+		ch <- prometheus.MustNewConstMetric(e.caseMismatches, prometheus.CounterValue, float64(caseMismatches), e.labelValues(host, key)...)
+	}
+	if host.DoubleCheck {
+		e.flapCountMutex.Lock()
+		flaps := e.flapCount[key]
+		e.flapCountMutex.Unlock()
+
+		ch <- prometheus.MustNewConstMetric(e.flaps, prometheus.CounterValue, float64(flaps), e.labelValues(host, key)...)
+	}
+
+	var srvs []*net.SRV
+	if key.recordType == RecordTypeSRV && e.srvTargetInfo {
+		srvs = resp.SRV
+	}
+	srvs, srvsTruncated := capInfoRecords(srvs, host.MaxInfoRecords, compareSRV)
+	nameservers, nameserversTruncated := capInfoRecords(authorities(resp.Msg), host.MaxInfoRecords, strings.Compare)
+	if host.MaxInfoRecords > 0 {
+		e.cappedCountMutex.Lock()
+		if srvsTruncated || nameserversTruncated {
+			e.cappedCount[key] += 1
+		}
+		truncated := e.cappedCount[key]
+		e.cappedCountMutex.Unlock()
+
+		ch <- prometheus.MustNewConstMetric(e.recordsTruncated, prometheus.CounterValue, float64(truncated), e.labelValues(host, key)...)
+	}
+
+	if key.recordType == RecordTypeSRV {
+		ch <- prometheus.MustNewConstMetric(e.srvRecords, prometheus.GaugeValue, float64(len(resp.SRV)), e.labelValues(host, key)...)
+
+		for _, srv := range srvs {
+			ch <- prometheus.MustNewConstMetric(e.srvTarget, prometheus.GaugeValue, 1, e.labelValues(host, key, srv.Target, strconv.Itoa(int(srv.Port)), strconv.Itoa(int(srv.Priority)), strconv.Itoa(int(srv.Weight)))...)
+		}
+	}
+
+	if key.recordType == RecordTypeTXT && host.Policy != "" {
+		records := policyRecords(host.Policy, answers)
+		ch <- prometheus.MustNewConstMetric(e.policyPresent, prometheus.GaugeValue, boolToFloat64(len(records) > 0), e.labelValues(host, key, host.Policy)...)
+		ch <- prometheus.MustNewConstMetric(e.policyValid, prometheus.GaugeValue, boolToFloat64(validPolicy(host.Policy, records)), e.labelValues(host, key, host.Policy)...)
+	}
+
+	if ttl, ok := minTTL(resp.Msg); ok {
+		ch <- prometheus.MustNewConstMetric(e.ttl, prometheus.GaugeValue, float64(ttl), e.labelValues(host, key)...)
+		if host.MinTTL > 0 || host.MaxTTL > 0 {
+			ch <- prometheus.MustNewConstMetric(e.ttlOK, prometheus.GaugeValue, boolToFloat64(ttlOK(host, ttl)), e.labelValues(host, key)...)
+		}
+	}
+
+	if e.maxProbeInterval > 0 {
+		next := e.scheduleProbe(key, resp.Msg)
+		ch <- prometheus.MustNewConstMetric(e.nextProbeTime, prometheus.GaugeValue, float64(next.Unix()), e.labelValues(host, key)...)
+	}
+
+	e.truncatedCountMutex.Lock()
+	if resp.Truncated {
+		e.truncatedCount[key] += 1
+	}
+	truncated := e.truncatedCount[key]
+	e.truncatedCountMutex.Unlock()
+	ch <- prometheus.MustNewConstMetric(e.truncated, prometheus.CounterValue, float64(truncated), e.labelValues(host, key)...)
+
+	e.partialCountMutex.Lock()
+	if err != nil && len(answers) > 0 {
+		e.partialCount[key] += 1
+	}
+	partial := e.partialCount[key]
+	e.partialCountMutex.Unlock()
+	ch <- prometheus.MustNewConstMetric(e.partial, prometheus.CounterValue, float64(partial), e.labelValues(host, key)...)
+
+	if host.SLOThreshold > 0 {
+		e.slowCountMutex.Lock()
+		if elapsed > host.SLOThreshold {
+			e.slowCount[key] += 1
+		}
+		slow := e.slowCount[key]
+		e.slowCountMutex.Unlock()
+		ch <- prometheus.MustNewConstMetric(e.slow, prometheus.CounterValue, float64(slow), e.labelValues(host, key)...)
+	}
+
+	e.spoofedCountMutex.Lock()
+	if errors.Is(err, errSpoofed) {
+		e.spoofedCount[key] += 1
+	}
+	spoofed := e.spoofedCount[key]
+	e.spoofedCountMutex.Unlock()
+	ch <- prometheus.MustNewConstMetric(e.spoofed, prometheus.CounterValue, float64(spoofed), e.labelValues(host, key)...)
+
+	e.lastAnswersMutex.Lock()
+	if err == nil {
+		normalized := normalizeAnswers(key.recordType, answers)
+		if previous, ok := e.lastAnswers[key]; ok && !sameAnswers(previous, normalized) {
+			e.changesCount[key] += 1
+		}
+		e.lastAnswers[key] = normalized
+	}
+	changes := e.changesCount[key]
+	e.lastAnswersMutex.Unlock()
+	ch <- prometheus.MustNewConstMetric(e.changes, prometheus.CounterValue, float64(changes), e.labelValues(host, key)...)
+
+	e.lastSuccessTimeMutex.Lock()
+	if err == nil {
+		e.lastSuccessTime[key] = time.Now()
+	}
+	lastSuccess := 0.0
+	if t, ok := e.lastSuccessTime[key]; ok {
+		lastSuccess = float64(t.UnixNano()) / 1e9
+	}
+	e.lastSuccessTimeMutex.Unlock()
+	ch <- prometheus.MustNewConstMetric(e.lastSuccess, prometheus.GaugeValue, lastSuccess, e.labelValues(host, key)...)
+
+	e.consecutiveFailuresCountMutex.Lock()
+	if err == nil {
+		e.consecutiveFailuresCount[key] = 0
+	} else {
+		e.consecutiveFailuresCount[key] += 1
+	}
+	consecutiveFailures := e.consecutiveFailuresCount[key]
+	e.consecutiveFailuresCountMutex.Unlock()
+	ch <- prometheus.MustNewConstMetric(e.consecutiveFailures, prometheus.GaugeValue, float64(consecutiveFailures), e.labelValues(host, key)...)
+
+	if resp.Msg != nil {
+		e.rcodeCountMutex.Lock()
+		if _, ok := e.rcodeCount[key]; !ok {
+			e.rcodeCount[key] = map[string]int{}
+		}
+		e.rcodeCount[key][dns.RcodeToString[resp.Msg.Rcode]] += 1
+		rcodeMetrics := []prometheus.Metric{}
+		for rcode, count := range e.rcodeCount[key] {
+			rcodeMetrics = append(rcodeMetrics, prometheus.MustNewConstMetric(e.rcodes, prometheus.CounterValue, float64(count), e.labelValues(host, key, rcode)...))
+		}
+		e.rcodeCountMutex.Unlock()
+
+		for _, metric := range rcodeMetrics {
+			ch <- metric
+		}
+	}
+
+	if resp.Msg != nil {
+		ch <- prometheus.MustNewConstMetric(e.queryBytes, prometheus.GaugeValue, float64(resp.QueryBytes), e.labelValues(host, key)...)
+		ch <- prometheus.MustNewConstMetric(e.responseBytes, prometheus.GaugeValue, float64(resp.ResponseBytes), e.labelValues(host, key)...)
+	}
+
+	if resp.Server != "" {
+		ch <- prometheus.MustNewConstMetric(e.server, prometheus.GaugeValue, 1, e.labelValues(host, key, resp.Server)...)
+	}
+	for _, authority := range nameservers {
+		ch <- prometheus.MustNewConstMetric(e.authority, prometheus.GaugeValue, 1, e.labelValues(host, key, authority)...)
+	}
+
+	if resp.ConnectDuration > 0 {
+		ch <- prometheus.MustNewConstMetric(e.connect, prometheus.GaugeValue, resp.ConnectDuration.Seconds(), e.labelValues(host, key)...)
+		ch <- prometheus.MustNewConstMetric(e.query, prometheus.GaugeValue, resp.QueryDuration.Seconds(), e.labelValues(host, key)...)
+	}
+
+	if resp.FirstByteDuration > 0 {
+		ch <- prometheus.MustNewConstMetric(e.firstByte, prometheus.GaugeValue, resp.FirstByteDuration.Seconds(), e.labelValues(host, key)...)
+	}
+
+	if host.DNSSEC && resp.Msg != nil {
+		ch <- prometheus.MustNewConstMetric(e.dnssec, prometheus.GaugeValue, boolToFloat64(dnssecValidated(resp.Msg)), e.labelValues(host, key)...)
+	}
+	if expiry, ok := rrsigExpiry(resp.Msg); ok {
+		ch <- prometheus.MustNewConstMetric(e.rrsigExpiry, prometheus.GaugeValue, float64(expiry.Unix()), e.labelValues(host, key)...)
+	}
+
+	if counts, ok := answerRecordTypes(resp.Msg); ok {
+		for rrtype, count := range counts {
+			ch <- prometheus.MustNewConstMetric(e.answerRecords, prometheus.GaugeValue, float64(count), e.labelValues(host, key, rrtype)...)
+		}
+	}
+	if depth, ok := cnameDepth(resp.Msg); ok {
+		ch <- prometheus.MustNewConstMetric(e.cnameDepth, prometheus.GaugeValue, float64(depth), e.labelValues(host, key)...)
+	}
+
+	if matched, ok := matchesExpected(host, key.recordType, answers); ok {
+		ch <- prometheus.MustNewConstMetric(e.match, prometheus.GaugeValue, boolToFloat64(matched), e.labelValues(host, key)...)
+	}
+	if missing, unexpected, ok := ipDrift(host, key.recordType, answers); ok {
+		ch <- prometheus.MustNewConstMetric(e.missingRecords, prometheus.GaugeValue, float64(missing), e.labelValues(host, key)...)
+		ch <- prometheus.MustNewConstMetric(e.unexpectedRecords, prometheus.GaugeValue, float64(unexpected), e.labelValues(host, key)...)
+	}
+	if within, ok := inExpectedCIDRs(host, key.recordType, answers); ok {
+		ch <- prometheus.MustNewConstMetric(e.inCIDR, prometheus.GaugeValue, boolToFloat64(within), e.labelValues(host, key)...)
+	}
+	if err != nil {
+		return nil
+	}
+	return answers
+}
+
+// lookup queries the resolver for the key, retrying temporary errors up to
+// the configured number of times with exponential backoff between attempts,
+// and returns the number of retries made.
+func (e *DNSCollector) lookup(ctx context.Context, host HostConfig, key probeKey) (Response, int, error) {
+	backoff := e.retryBackoff
+
+	for attempt := 0; ; attempt++ {
+		resp, err := e.resolver(key).Lookup(ctx, Query{
+			Host:       e.queryName(key),
+			RecordType: key.recordType,
+			DNSSEC:     host.DNSSEC,
+			Class:      e.hostClass(host),
+			ECS:        key.ecs,
+			NSID:       e.nsid,
+
+			RandomizeCase:    e.caseRandomization,
+			NoRecursion:      key.flags.noRecursion,
+			CheckingDisabled: key.flags.checkingDisabled,
+		})
+		if err == nil || attempt >= e.maxRetries || classifyError(err) != ErrorTypeTemporary {
+			return resp, attempt, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return resp, attempt, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// probeKeys returns the keys probing the host for the record type with each
+// of the resolvers from each source address.
+func (e *DNSCollector) probeKeys(host HostConfig, recordType string, resolvers []string) []probeKey {
+	subnets := []string{""}
+	if len(host.ECS) > 0 {
+		subnets = host.ECS
+	}
+
+	keys := []probeKey{}
+	for _, resolver := range resolvers {
+		for _, source := range e.sources {
+			for _, ecs := range subnets {
+				for _, flags := range host.queryFlags() {
+					keys = append(keys, probeKey{host: host.Name, recordType: recordType, resolver: resolver, mode: e.hostMode(host), source: source, ecs: ecs, flags: flags})
+				}
+			}
+		}
+	}
+
+	if e.comparesWithSystem(host, recordType) {
+		for _, source := range e.sources {
+			keys = append(keys, probeKey{host: host.Name, recordType: recordType, resolver: SystemResolver, mode: ModeStdlib, source: source})
+		}
+	}
+
+	return keys
+}
+
+// comparesWithSystem returns whether the host is also probed for the record
+// type with the system resolver, which is only possible for lookups the
+// stdlib can make.
+func (e *DNSCollector) comparesWithSystem(host HostConfig, recordType string) bool {
+	if !e.compareWithSystem || host.Resolver == SystemResolver {
+		return false
+	}
+	if class := e.hostClass(host); class != "" && class != dns.ClassToString[dns.ClassINET] {
+		return false
+	}
+
+	return supportsRecordType(ModeStdlib, recordType)
+}
+
+// resolver returns the resolver to probe the key with.
+func (e *DNSCollector) resolver(key probeKey) Resolver {
+	e.resolversMutex.RLock()
+	defer e.resolversMutex.RUnlock()
+
+	return e.resolvers[resolverKey{address: key.resolver, mode: key.mode, source: key.source}]
+}
+
+// AddResolvers creates the resolvers of the global mode and resolvers, and
+// of any the hosts override them with, that don't already exist, so hosts
+// added on reload can be probed.
+func (e *DNSCollector) AddResolvers(hosts []HostConfig) error {
+	keys := []resolverKey{}
+	for _, source := range e.sources {
+		for _, address := range e.resolverAddresses {
+			keys = append(keys, resolverKey{address: address, mode: e.mode, source: source})
+		}
+		for _, host := range hosts {
+			for _, address := range e.hostResolverAddresses(host) {
+				keys = append(keys, resolverKey{address: address, mode: e.hostMode(host), source: source})
+			}
+		}
+		if e.compareWithSystem {
+			keys = append(keys, resolverKey{address: SystemResolver, mode: ModeStdlib, source: source})
+		}
+	}
+
+	e.resolversMutex.Lock()
+	defer e.resolversMutex.Unlock()
+
+	for _, key := range keys {
+		if _, ok := e.resolvers[key]; ok {
+			continue
+		}
+
+		protocol, err := resolverProtocol(key.mode, e.protocol)
+		if err != nil {
+			return err
+		}
+
+		options := e.resolverOptions
+		options.protocol = protocol
+		options.source = key.source
+		options.dialer = e.dialers[key.source]
+
+		resolver, err := newResolver(key.mode, key.address, options)
+		if err != nil {
+			return err
+		}
+		e.resolvers[key] = resolver
+	}
+
+	return nil
+}
+
+// keyProtocol returns the transport the key is probed over. The protocol was
+// validated when its resolver was created.
+func (e *DNSCollector) keyProtocol(key probeKey) string {
+	protocol, _ := resolverProtocol(key.mode, e.protocol)
+	return protocol
+}
+
+// hostMode returns the mode the host is probed in, which overrides the global
+// one.
+func (e *DNSCollector) hostMode(host HostConfig) string {
+	if host.Mode != "" {
+		return host.Mode
+	}
+
+	return e.mode
+}
+
+// hostResolverAddresses returns all the resolvers the host may be probed
+// with, which are its own if it overrides the global ones.
+func (e *DNSCollector) hostResolverAddresses(host HostConfig) []string {
+	if host.Resolver != "" {
+		return []string{host.Resolver}
+	}
+
+	return e.resolverAddresses
+}
+
+// hostResolvers returns the resolvers to probe the host with, which are all
+// of them unless rotating, in which case it advances the host's rotation. A
+// host overriding the resolvers isn't rotated.
+func (e *DNSCollector) hostResolvers(host HostConfig) []string {
+	if host.Resolver != "" {
+		return []string{host.Resolver}
+	}
+	if e.resolverRotation == nil {
+		return e.resolverAddresses
+	}
+
+	e.rotationIndexMutex.Lock()
+	defer e.rotationIndexMutex.Unlock()
+
+	i := e.rotationIndex[host.Name]
+	e.rotationIndex[host.Name] = (i + 1) % len(e.resolverRotation)
+
+	return []string{e.resolverRotation[i]}
+}
+
+// collectGroups sends the resolutions and errors of the configured hosts
+// with a group summed by group. As they only count current members, the
+// totals drop when a host leaves its group.
+func (e *DNSCollector) collectGroups(ch chan<- prometheus.Metric) {
+	groups := map[string]string{}
+	for _, host := range e.Hosts() {
+		if host.Group != "" {
+			groups[host.Name] = host.Group
+		}
+	}
+	if len(groups) == 0 {
+		return
+	}
+
+	totals := map[string]int{}
+	groupErrors := map[string]int{}
+	for _, group := range groups {
+		totals[group] = 0
+		groupErrors[group] = 0
+	}
+
+	e.totalCountMutex.Lock()
+	for key, count := range e.totalCount {
+		if group, ok := groups[key.host]; ok {
+			totals[group] += count
+		}
+	}
+	e.totalCountMutex.Unlock()
+
+	e.totalErrorCountMutex.Lock()
+	for key, count := range e.totalErrorCount {
+		if group, ok := groups[key.host]; ok {
+			groupErrors[group] += count
+		}
+	}
+	e.totalErrorCountMutex.Unlock()
+
+	for group, total := range totals {
+		ch <- prometheus.MustNewConstMetric(e.groupTotal, prometheus.CounterValue, float64(total), group)
+		ch <- prometheus.MustNewConstMetric(e.groupTotalError, prometheus.CounterValue, float64(groupErrors[group]), group)
+	}
+}
+
+// stuckCount returns the number of probes in flight past their timeout, which
+// should not happen as lookups are bounded by it.
+func (e *DNSCollector) stuckCount() int {
+	e.inflightDeadlinesMutex.Lock()
+	defer e.inflightDeadlinesMutex.Unlock()
+
+	now := time.Now()
+	stuck := 0
+	for _, deadline := range e.inflightDeadlines {
+		if now.After(deadline) {
+			stuck++
+		}
+	}
+
+	return stuck
+}
+
+// Failing returns whether the most recent resolution of any probe of the
+// most recent round failed. Probes no longer made, such as of resolvers
+// rotated away from or record types removed on reload, aren't counted.
+func (e *DNSCollector) Failing() bool {
+	e.lastRoundKeysMutex.Lock()
+	round := e.lastRoundKeys
+	e.lastRoundKeysMutex.Unlock()
+
+	e.consecutiveFailuresCountMutex.Lock()
+	defer e.consecutiveFailuresCountMutex.Unlock()
+
+	for key := range round {
+		if e.consecutiveFailuresCount[key] > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hostTimeout returns the timeout for lookups of the host, which overrides
+// the globally configured one.
+func (e *DNSCollector) hostTimeout(host HostConfig) time.Duration {
+	if host.Timeout > 0 {
+		return host.Timeout
+	}
+
+	return e.timeout
+}
+
+// hostClass returns the query class for the host, which overrides the
+// globally configured one.
+func (e *DNSCollector) hostClass(host HostConfig) string {
+	if host.Class != "" {
+		return host.Class
+	}
+
+	return e.class
+}
+
+// hostRecordTypes returns the record types to look up for the host, which
+// override the globally configured ones.
+func (e *DNSCollector) hostRecordTypes(host HostConfig) []string {
+	if len(host.RecordTypes) > 0 {
+		return host.RecordTypes
+	}
+
+	return e.recordTypes
+}
+
+// maxNSIDs is the most NSIDs, such as of the servers behind an anycast
+// address, that the latency of a probe is labelled with, beyond which the
+// NSID label is left empty to bound cardinality.
+const maxNSIDs = 16
+
+// nsidCount returns the number of distinct NSIDs the latency of the probe is
+// labelled with, and must be called with latenciesMutex held.
+func (e *DNSCollector) nsidCount(key probeKey) int {
+	nsids := map[string]bool{}
+	for _, series := range e.latencyKeys[key] {
+		if series.nsid != "" {
+			nsids[series.nsid] = true
+		}
+	}
+
+	return len(nsids)
+}
+
+// cacheHit guesses whether a response was served from a resolver's cache.
+// Resolvers count down the TTL of cached records, so a response with a TTL
+// lower than the highest seen for the probe is taken to be cached, and one
+// at the highest TTL to be freshly fetched. This is an approximation: the
+// first response is always a miss, a cache refreshed just before the query
+// looks like a miss, and a zone lowering its TTLs looks like hits until the
+// highest seen TTL is reached again. Responses without TTLs are misses.
+func (e *DNSCollector) cacheHit(key probeKey, msg *dns.Msg) bool {
+	ttl, ok := minTTL(msg)
+	if !ok {
+		return false
+	}
+
+	e.maxTTLsMutex.Lock()
+	defer e.maxTTLsMutex.Unlock()
+
+	if ttl >= e.maxTTLs[key] {
+		e.maxTTLs[key] = ttl
+		return false
+	}
+	return true
+}
+
+// queryName returns the name to look up for the key, made absolute if
+// configured so that search domains are not applied.
+func (e *DNSCollector) queryName(key probeKey) string {
+	if !e.absoluteNames || key.recordType == RecordTypePTR || strings.HasSuffix(key.host, ".") {
+		return key.host
+	}
+
+	return key.host + "."
+}
+
+func (e *DNSCollector) labelValues(host HostConfig, key probeKey, extra ...string) []string {
+	values := []string{key.host, key.recordType, recordTypeNetwork(key.recordType), key.resolver, e.keyProtocol(key), key.mode, key.source, key.ecs, key.flags.String()}
+	for _, labelKey := range e.hostLabelKeys {
+		values = append(values, host.Labels[labelKey])
+	}
+
+	return append(values, extra...)
+}
+
+// capInfoRecords returns the first limit records in the order given by cmp, or
+// all of them if limit is unset, and whether any were dropped.
+func capInfoRecords[T any](records []T, limit int, cmp func(a, b T) int) ([]T, bool) {
+	if limit <= 0 || len(records) <= limit {
+		return records, false
+	}
+
+	return slices.SortedFunc(slices.Values(records), cmp)[:limit], true
+}
+
+func boolToFloat64(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func copyBuckets(buckets map[float64]uint64) map[float64]uint64 {
+	c := make(map[float64]uint64, len(buckets))
+	for bucket, count := range buckets {
+		c[bucket] = count
+	}
+
+	return c
+}
+
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+// readyzHandler responds with a 503 once the exporter is shutting down, so
+// that load balancers stop sending it scrapes while it drains, unlike
+// healthzHandler, which responds with a 200 for as long as it serves.
+func readyzHandler(ready *atomic.Bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ready.Load() {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}
+}
+
+func main() {
+	configFile := flag.String("config.file", "", "Comma-separated paths or globs of the YAML configuration files, or - to read from stdin.")
+	listenAddress := flag.String("web.listen-address", ":8000", "Address to listen on for HTTP requests.")
+	telemetryPath := flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
+	tlsCertFile := flag.String("web.tls-cert-file", "", "Path to the TLS certificate file to serve HTTPS with.")
+	tlsKeyFile := flag.String("web.tls-key-file", "", "Path to the TLS key file to serve HTTPS with.")
+	authUser := flag.String("web.auth-user", "", "Username required to access the metrics endpoints.")
+	authPasswordHash := flag.String("web.auth-password-hash", "", "Bcrypt hash of the password required to access the metrics endpoints.")
+	enablePprof := flag.Bool("web.enable-pprof", false, "Serve Go profiling data under /debug/pprof/.")
+	failOnErrorFlag := flag.Bool("web.fail-on-error", false, "Respond to scrapes of the metrics endpoint with a 500 if the most recent resolution of any host failed.")
+	shutdownDelay := flag.Duration("web.shutdown-delay", 0, "Time to keep serving after SIGTERM, with /readyz failing, before shutting down, so load balancers can stop sending scrapes.")
+	maxRequestsInFlight := flag.Int("web.max-requests", 0, "Maximum number of concurrent scrapes of the metrics endpoint, beyond which scrapes are rejected with a 503, or 0 for no limit.")
+	probeRateLimit := flag.Float64("probe.rate-limit", 0, "Maximum number of /probe requests per second, or 0 for no limit.")
+	once := flag.Bool("once", false, "Probe every host once, print the metrics to stdout, and exit non-zero if any resolution failed.")
+	startupCheck := flag.Bool("startup-check", false, "Resolve every host once at startup and log how many succeeded, without aborting on failures.")
+	rejectEmpty := flag.Bool("config.reject-empty", false, "Refuse to start with, or reload, a config in which no hosts are enabled.")
+	checkConfig := flag.Bool("check-config", false, "Validate the configuration and exit.")
+	pushGatewayURL := flag.String("push.gateway-url", "", "URL of a Pushgateway to push metrics to, in addition to serving them.")
+	pushJob := flag.String("push.job", DefaultPushJob, "Job name to push metrics under.")
+	pushGrouping := flag.String("push.grouping", "", "Comma-separated name=value grouping labels to push metrics under.")
+	pushInterval := flag.Duration("push.interval", DefaultPushInterval, "Interval between pushes, each probing every host, unless probing in the background, when metrics are pushed at the probe interval.")
+	flag.StringVar(&Namespace, "metrics.namespace", Namespace, "Namespace prefixing the names of exported metrics.")
+	flag.StringVar(&Subsystem, "metrics.subsystem", Subsystem, "Subsystem added to the names of exported metrics after the namespace.")
+	logFormat := flag.String("log.format", LogFormatJSON, "Log output format, one of 'text' or 'json'.")
+	logLevel := flag.String("log.level", LogLevelInfo, "Only log messages at or above this level, one of 'error', 'warn', 'info', or 'debug'. Failed lookups are logged individually at 'debug', and summarised periodically at 'warn'.")
+	flag.Parse()
+
+	logger, err := newLogger(os.Stderr, *logFormat, *logLevel)
+	if err != nil {
+		fatal("could not create logger", "err", err)
+	}
+	slog.SetDefault(logger)
+
+	if !model.IsValidMetricName(model.LabelValue(prometheus.BuildFQName(Namespace, Subsystem, "up"))) {
+		fatal("invalid metrics namespace or subsystem", "namespace", Namespace, "subsystem", Subsystem)
+	}
+	if err := validateTLSFiles(*tlsCertFile, *tlsKeyFile); err != nil {
+		fatal("invalid tls configuration", "err", err)
+	}
+	if err := validateBasicAuth(*authUser, *authPasswordHash); err != nil {
+		fatal("invalid basic auth configuration", "err", err)
+	}
+	grouping, err := parseGroupingLabels(*pushGrouping)
+	if err != nil {
+		fatal("invalid push configuration", "err", err)
+	}
+	if *pushInterval <= 0 {
+		fatal("push interval must be positive", "interval", *pushInterval)
+	}
+
+	if *checkConfig {
+		if err := validateConfig(*configFile, os.Getenv(HostsEnvVar)); err != nil {
+			fmt.Fprintf(os.Stderr, "config is invalid: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("config is valid")
+		os.Exit(0)
+	}
+
+	config, err := loadConfig(*configFile, os.Getenv(HostsEnvVar))
+	if err != nil {
+		fatal("could not load config", "err", err)
+	}
+	if err := checkEnabledHosts(config.Hosts, *rejectEmpty); err != nil {
+		fatal("could not load config", "err", err)
+	}
+
+	dnsCollector, err := NewDNSCollector(config)
+	if err != nil {
+		fatal("could not create dns collector", "err", err)
+	}
+
+	if config.MaxConcurrency <= 0 {
+		slog.Info("max_concurrency not configured, defaulting by available CPUs", "max_concurrency", cap(dnsCollector.semaphore), "gomaxprocs", runtime.GOMAXPROCS(0))
+	}
+
+	if *once {
+		dnsCollector.probeInterval = 0
+
+		var push func(prometheus.Gatherer) error
+		if *pushGatewayURL != "" {
+			push = func(gatherer prometheus.Gatherer) error {
+				return newPusher(*pushGatewayURL, *pushJob, grouping, gatherer).Push()
+			}
+		}
+
+		failed, err := runOnce(os.Stdout, push, dnsCollector, newBuildInfoCollector(), newSystemNameserversCollector())
+		if err != nil {
+			fatal("could not probe hosts", "err", err)
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *startupCheck {
+		dnsCollector.StartupCheck(context.Background(), startupCheckTimeout)
+	}
+
+	// Hosts served by a listener are probed by its collector instead, so
+	// that they are neither queried twice nor served on the main endpoint.
+	dnsCollector.SetHosts(unlistenedHosts(config.Listeners, config.Hosts))
+
+	registry := prometheus.NewRegistry()
+	if err := dnsCollector.Register(registry); err != nil {
+		fatal("could not register dns collector", "err", err)
+	}
+	registry.MustRegister(newBuildInfoCollector())
+	nameservers := newSystemNameserversCollector()
+	registry.MustRegister(nameservers)
+	registry.MustRegister(collectors.NewGoCollector())
+	registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	handlerOpts := promhttp.HandlerOpts{
+		ErrorLog:            slog.NewLogLogger(logger.Handler(), slog.LevelError),
+		ErrorHandling:       promhttp.ContinueOnError,
+		Timeout:             dnsCollector.scrapeTimeout + handlerTimeoutGrace,
+		EnableOpenMetrics:   true,
+		MaxRequestsInFlight: *maxRequestsInFlight,
+	}
+
+	listeners := []*listener{}
+	for _, listenerConfig := range config.Listeners {
+		if listenerConfig.Address == *listenAddress {
+			fatal("listener address is already listened on", "address", listenerConfig.Address)
+		}
+		listener, err := newListener(config, listenerConfig, *telemetryPath, handlerOpts, *authUser, *authPasswordHash)
+		if err != nil {
+			fatal("could not create listener", "address", listenerConfig.Address, "err", err)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	// Background work is stopped on shutdown, and the prober waited for, so
+	// that probes aren't left in flight.
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	var probers sync.WaitGroup
+	if config.ProbeInterval > 0 {
+		for _, collector := range append([]*DNSCollector{dnsCollector}, listenerCollectors(listeners)...) {
+			probers.Add(1)
+			go func() {
+				defer probers.Done()
+				collector.Run(background)
+			}()
+		}
+	}
+	probing := make(chan struct{})
+	go func() {
+		probers.Wait()
+		close(probing)
+	}()
+	go dnsCollector.LogFailures(background, failureSummaryInterval)
+
+	if *pushGatewayURL != "" {
+		interval := *pushInterval
+		if config.ProbeInterval > 0 {
+			interval = config.ProbeInterval
+		}
+		go pushMetrics(background, newPusher(*pushGatewayURL, *pushJob, grouping, registry), interval)
+	}
+
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+
+		for range hup {
+			nameservers.Reload()
+			if err := reloadHosts(dnsCollector, listeners, *configFile, os.Getenv(HostsEnvVar), *rejectEmpty); err != nil {
+				slog.Error("could not reload config", "err", err)
+			}
+		}
+	}()
+
+	mux := http.NewServeMux()
+	metricsHandler := promhttp.HandlerFor(registry, handlerOpts)
+
+	if *failOnErrorFlag {
+		metricsHandler = failOnError(metricsHandler, dnsCollector.Failing)
+	}
+
+	mux.Handle(*telemetryPath, basicAuth(metricsHandler, *authUser, *authPasswordHash))
+	probe, err := probeHandler(config, dnsCollector, handlerOpts, *probeRateLimit)
+	if err != nil {
+		fatal("could not create probe handler", "err", err)
+	}
+	mux.Handle("/probe", basicAuth(probe, *authUser, *authPasswordHash))
+	debugResolve, err := debugResolveHandler(dnsCollector, config)
+	if err != nil {
+		fatal("could not create debug resolve handler", "err", err)
+	}
+	mux.Handle("/debug/resolve", basicAuth(debugResolve, *authUser, *authPasswordHash))
+	currentConfig := func() Config {
+		current := config
+		current.Hosts = dnsCollector.Hosts()
+		return current
+	}
+
+	mux.Handle("/config", basicAuth(configHandler(currentConfig, WebConfig{
+		ListenAddress:    *listenAddress,
+		TelemetryPath:    *telemetryPath,
+		TLSCertFile:      *tlsCertFile,
+		TLSKeyFile:       *tlsKeyFile,
+		AuthUser:         *authUser,
+		AuthPasswordHash: *authPasswordHash,
+	}), *authUser, *authPasswordHash))
+	if *enablePprof {
+		mux.Handle("/debug/pprof/", basicAuth(http.HandlerFunc(pprof.Index), *authUser, *authPasswordHash))
+		mux.Handle("/debug/pprof/cmdline", basicAuth(http.HandlerFunc(pprof.Cmdline), *authUser, *authPasswordHash))
+		mux.Handle("/debug/pprof/profile", basicAuth(http.HandlerFunc(pprof.Profile), *authUser, *authPasswordHash))
+		mux.Handle("/debug/pprof/symbol", basicAuth(http.HandlerFunc(pprof.Symbol), *authUser, *authPasswordHash))
+		mux.Handle("/debug/pprof/trace", basicAuth(http.HandlerFunc(pprof.Trace), *authUser, *authPasswordHash))
+	}
+	mux.HandleFunc("/healthz", healthzHandler)
+	var ready atomic.Bool
+	ready.Store(true)
+	mux.HandleFunc("/readyz", readyzHandler(&ready))
+	if *telemetryPath != "/" {
+		mux.HandleFunc("/", landingPageHandler(*telemetryPath))
+	}
+
+	server := &http.Server{Addr: *listenAddress, Handler: mux}
+
+	done := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+		sig := <-signals
+
+		slog.Info("shutting down", "signal", sig)
+		ready.Store(false)
+		time.Sleep(*shutdownDelay)
+		stopBackground()
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("could not shut down cleanly", "err", err)
+		}
+		for _, listener := range listeners {
+			if err := listener.server.Shutdown(ctx); err != nil {
+				slog.Error("could not shut down listener cleanly", "address", listener.config.Address, "err", err)
+			}
+		}
+
+		select {
+		case <-probing:
+		case <-ctx.Done():
+			slog.Error("could not stop background probing", "err", ctx.Err())
+		}
+
+		close(done)
+	}()
+
+	for _, listener := range listeners {
+		go func() {
+			if err := listenAndServe(listener.server, *tlsCertFile, *tlsKeyFile); err != http.ErrServerClosed {
+				fatal("could not listen", "address", listener.config.Address, "err", err)
+			}
+		}()
+	}
+
+	if err := listenAndServe(server, *tlsCertFile, *tlsKeyFile); err != http.ErrServerClosed {
+		fatal("could not listen", "address", *listenAddress, "err", err)
+	}
 
-	http.Handle("/metrics", prometheus.Handler())
-	http.ListenAndServe("localhost:8000", nil)
+	<-done
+	slog.Info("shutdown complete")
 }
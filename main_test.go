@@ -0,0 +1,765 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCollectSuccess(t *testing.T) {
+	collector := newFakeCollector(t, testConfig("example.org"), answering("192.0.2.1", "192.0.2.2"))
+
+	families := gather(t, collector)
+	labels := map[string]string{"host": "example.org", "qtype": RecordTypeA}
+
+	if total := metricValue(t, families, "dns_exporter_resolution_total", labels); total != 1 {
+		t.Errorf("expected 1 resolution, got %v", total)
+	}
+	for _, errorType := range errorTypes {
+		errorLabels := map[string]string{"host": "example.org", "error_type": errorType}
+		if errors := metricValue(t, families, "dns_exporter_resolution_error_total", errorLabels); errors != 0 {
+			t.Errorf("expected no %s errors, got %v", errorType, errors)
+		}
+	}
+	if records := metricValue(t, families, "dns_exporter_resolution_records", labels); records != 2 {
+		t.Errorf("expected 2 records, got %v", records)
+	}
+
+	latency, ok := findMetric(families["dns_exporter_resolution_seconds"], labels)
+	if !ok {
+		t.Fatal("no dns_exporter_resolution_seconds metric")
+	}
+	if count := latency.GetHistogram().GetSampleCount(); count != 1 {
+		t.Errorf("expected 1 latency observation, got %d", count)
+	}
+}
+
+func TestCollectProbesEachHostOncePerScrape(t *testing.T) {
+	lookups := map[string]int{}
+	var lookupsMutex sync.Mutex
+	collector := newFakeCollector(t, testConfig("example.org", "google.com"), fakeResolver(func(ctx context.Context, q Query) (Response, error) {
+		lookupsMutex.Lock()
+		lookups[q.Host] += 1
+		lookupsMutex.Unlock()
+		return Response{Answers: []string{"192.0.2.1"}}, nil
+	}))
+
+	for scrape := 1; scrape <= 3; scrape++ {
+		families := gather(t, collector)
+
+		lookupsMutex.Lock()
+		for _, host := range []string{"example.org", "google.com"} {
+			if lookups[host] != scrape {
+				t.Errorf("scrape %d: expected %d lookups of %s, got %d", scrape, scrape, host, lookups[host])
+			}
+			if total := metricValue(t, families, "dns_exporter_resolution_total", map[string]string{"host": host}); total != float64(scrape) {
+				t.Errorf("scrape %d: expected %d resolutions of %s, got %v", scrape, scrape, host, total)
+			}
+		}
+		lookupsMutex.Unlock()
+	}
+}
+
+func TestResolveHostCountsOneAttempt(t *testing.T) {
+	collector := newFakeCollector(t, testConfig("example.org"), answering("192.0.2.1"))
+	host := collector.Hosts()[0]
+	key := probeKeyOf(collector, host)
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		collectMetrics(func(ch chan<- prometheus.Metric) {
+			collector.resolveHost(context.Background(), ch, host, key)
+		})
+
+		collector.totalCountMutex.Lock()
+		total := collector.totalCount[key]
+		collector.totalCountMutex.Unlock()
+		if total != attempt {
+			t.Errorf("expected a total of %d after %d attempts, got %d", attempt, attempt, total)
+		}
+	}
+}
+
+func TestCollectErrorTypes(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		errorType string
+	}{
+		{name: "nxdomain", err: &responseError{errorType: ErrorTypeNXDomain, err: &net.DNSError{Err: "no such host", IsNotFound: true}}, errorType: ErrorTypeNXDomain},
+		{name: "not found", err: &net.DNSError{Err: "no such host", IsNotFound: true}, errorType: ErrorTypeNotFound},
+		{name: "dns timeout", err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}, errorType: ErrorTypeTimeout},
+		{name: "http", err: &httpError{err: errors.New("unexpected status code 502")}, errorType: ErrorTypeHTTP},
+		{name: "tls", err: &tlsError{err: errors.New("certificate signed by unknown authority")}, errorType: ErrorTypeTLS},
+		{name: "unknown", err: errors.New("boom"), errorType: ErrorTypeUnknown},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			collector := newFakeCollector(t, testConfig("example.org"), fakeResolver(func(ctx context.Context, q Query) (Response, error) {
+				return Response{}, test.err
+			}))
+
+			families := gather(t, collector)
+			for _, errorType := range errorTypes {
+				expected := 0.0
+				if errorType == test.errorType {
+					expected = 1
+				}
+
+				labels := map[string]string{"host": "example.org", "error_type": errorType}
+				if errors := metricValue(t, families, "dns_exporter_resolution_error_total", labels); errors != expected {
+					t.Errorf("expected %v %s errors, got %v", expected, errorType, errors)
+				}
+			}
+		})
+	}
+}
+
+func TestCollectIgnoreErrors(t *testing.T) {
+	nxdomain := &responseError{errorType: ErrorTypeNXDomain, err: &net.DNSError{Err: "no such host", IsNotFound: true}}
+	temporary := &net.DNSError{Err: "server misbehaving", IsTemporary: true}
+
+	tests := []struct {
+		name         string
+		ignoreErrors []string
+		err          error
+		success      float64
+	}{
+		{name: "ignored", ignoreErrors: []string{ErrorTypeNXDomain}, err: nxdomain, success: 1},
+		{name: "not ignored", err: nxdomain, success: 0},
+		{name: "other error", ignoreErrors: []string{ErrorTypeNXDomain}, err: temporary, success: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := testConfig()
+			config.Hosts = []HostConfig{{Name: "missing.example.org", IgnoreErrors: test.ignoreErrors}}
+			collector := newFakeCollector(t, config, fakeResolver(func(ctx context.Context, q Query) (Response, error) {
+				return Response{}, test.err
+			}))
+
+			families := gather(t, collector)
+			labels := map[string]string{"host": "missing.example.org"}
+			if success := metricValue(t, families, "dns_exporter_resolution_success", labels); success != test.success {
+				t.Errorf("expected success %v, got %v", test.success, success)
+			}
+			if total := metricValue(t, families, "dns_exporter_resolution_total", labels); total != 1 {
+				t.Errorf("expected a total of 1, got %v", total)
+			}
+
+			errors := 0.0
+			for _, errorType := range errorTypes {
+				labels := map[string]string{"host": "missing.example.org", "error_type": errorType}
+				errors += metricValue(t, families, "dns_exporter_resolution_error_total", labels)
+			}
+			if errors != 1-test.success {
+				t.Errorf("expected %v errors, got %v", 1-test.success, errors)
+			}
+		})
+	}
+}
+
+func TestProbe(t *testing.T) {
+	nxdomain := &responseError{errorType: ErrorTypeNXDomain, err: &net.DNSError{Err: "no such host", IsNotFound: true}}
+	temporary := &net.DNSError{Err: "server misbehaving", IsTemporary: true}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		host      HostConfig
+		responses []error
+		answers   []string
+		errorType string
+		lookups   int
+		retries   int
+	}{
+		{name: "success", host: HostConfig{Name: "example.org"}, answers: []string{"192.0.2.1"}, lookups: 1},
+		{name: "insufficient answers", host: HostConfig{Name: "example.org", MinAnswers: 2}, answers: []string{"192.0.2.1"}, errorType: ErrorTypeInsufficientAnswers, lookups: 1},
+		{name: "ignored error", host: HostConfig{Name: "example.org", IgnoreErrors: []string{ErrorTypeNXDomain}}, responses: []error{nxdomain}, lookups: 1},
+		{name: "error", host: HostConfig{Name: "example.org"}, responses: []error{nxdomain}, errorType: ErrorTypeNXDomain, lookups: 1},
+		{name: "retried", host: HostConfig{Name: "example.org"}, responses: []error{temporary, temporary}, answers: []string{"192.0.2.1"}, lookups: 3, retries: 2},
+		{name: "cancelled", ctx: cancelled, host: HostConfig{Name: "example.org"}, answers: []string{"192.0.2.1"}, errorType: ErrorTypeTimeout},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := testConfig()
+			config.Hosts = []HostConfig{test.host}
+			config.Retries = 2
+			config.RetryBackoff = time.Millisecond
+
+			lookups := 0
+			collector := newFakeCollector(t, config, fakeResolver(func(ctx context.Context, q Query) (Response, error) {
+				lookups++
+				if lookups <= len(test.responses) {
+					return Response{}, test.responses[lookups-1]
+				}
+				return Response{Answers: test.answers}, nil
+			}))
+
+			ctx := test.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			key := probeKeyOf(collector, test.host)
+			result := collector.probe(ctx, test.host, key)
+
+			errorType := ""
+			if result.err != nil {
+				errorType = classifyError(result.err)
+			}
+			if errorType != test.errorType {
+				t.Errorf("expected error type '%s', got '%s' (%v)", test.errorType, errorType, result.err)
+			}
+			if lookups != test.lookups {
+				t.Errorf("expected %d lookups, got %d", test.lookups, lookups)
+			}
+			if result.retries != test.retries {
+				t.Errorf("expected %d retries, got %d", test.retries, result.retries)
+			}
+			if retries := collector.retriesCount[key]; retries != 0 {
+				t.Errorf("expected probing to record no retries, got %d", retries)
+			}
+		})
+	}
+}
+
+func TestCollectRetries(t *testing.T) {
+	temporary := &net.DNSError{Err: "server misbehaving", IsTemporary: true}
+
+	tests := []struct {
+		name      string
+		failures  int32
+		retries   int
+		errorType string
+	}{
+		{name: "succeeds on retry", failures: 2, retries: 2},
+		{name: "retries exhausted", failures: 3, retries: 2, errorType: ErrorTypeTemporary},
+		{name: "no retries", failures: 1, retries: 0, errorType: ErrorTypeTemporary},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := testConfig("example.org")
+			config.Retries = test.retries
+			config.RetryBackoff = time.Millisecond
+
+			var lookups atomic.Int32
+			collector := newFakeCollector(t, config, fakeResolver(func(ctx context.Context, q Query) (Response, error) {
+				if lookups.Add(1) <= test.failures {
+					return Response{}, temporary
+				}
+				return Response{Answers: []string{"192.0.2.1"}}, nil
+			}))
+
+			families := gather(t, collector)
+			labels := map[string]string{"host": "example.org"}
+
+			if retries := metricValue(t, families, "dns_exporter_resolution_retries_total", labels); retries != float64(test.retries) {
+				t.Errorf("expected %d retries, got %v", test.retries, retries)
+			}
+			if lookups := lookups.Load(); lookups != int32(test.retries)+1 {
+				t.Errorf("expected %d lookups, got %d", test.retries+1, lookups)
+			}
+			for _, errorType := range errorTypes {
+				expected := 0.0
+				if errorType == test.errorType {
+					expected = 1
+				}
+
+				errorLabels := map[string]string{"host": "example.org", "error_type": errorType}
+				if errors := metricValue(t, families, "dns_exporter_resolution_error_total", errorLabels); errors != expected {
+					t.Errorf("expected %v %s errors, got %v", expected, errorType, errors)
+				}
+			}
+		})
+	}
+}
+
+func TestCollectTimeout(t *testing.T) {
+	config := testConfig("example.org")
+	config.Timeout = 20 * time.Millisecond
+
+	collector := newFakeCollector(t, config, fakeResolver(func(ctx context.Context, q Query) (Response, error) {
+		<-ctx.Done()
+		return Response{}, ctx.Err()
+	}))
+
+	start := time.Now()
+	families := gather(t, collector)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the scrape to be bounded by the timeout, took %s", elapsed)
+	}
+
+	labels := map[string]string{"host": "example.org", "error_type": ErrorTypeTimeout}
+	if errors := metricValue(t, families, "dns_exporter_resolution_error_total", labels); errors != 1 {
+		t.Errorf("expected 1 timeout error, got %v", errors)
+	}
+}
+
+func TestHealthzHandler(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	healthzHandler(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", recorder.Code)
+	}
+	if body := recorder.Body.String(); body != "ok" {
+		t.Errorf("expected body 'ok', got '%s'", body)
+	}
+}
+
+func TestReadyzHandler(t *testing.T) {
+	var ready atomic.Bool
+	handler := readyzHandler(&ready)
+
+	for _, test := range []struct {
+		ready  bool
+		status int
+	}{
+		{ready: true, status: http.StatusOK},
+		{ready: false, status: http.StatusServiceUnavailable},
+	} {
+		ready.Store(test.ready)
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if recorder.Code != test.status {
+			t.Errorf("ready %t: expected status %d, got %d", test.ready, test.status, recorder.Code)
+		}
+	}
+}
+
+func TestCollectBoundsConcurrency(t *testing.T) {
+	hosts := []string{}
+	for i := 0; i < 20; i++ {
+		hosts = append(hosts, fmt.Sprintf("host%d.example.org", i))
+	}
+	config := testConfig(hosts...)
+	config.MaxConcurrency = 3
+
+	var inflight, maxInflight atomic.Int32
+	collector := newFakeCollector(t, config, fakeResolver(func(ctx context.Context, q Query) (Response, error) {
+		current := inflight.Add(1)
+		defer inflight.Add(-1)
+		for {
+			previous := maxInflight.Load()
+			if current <= previous || maxInflight.CompareAndSwap(previous, current) {
+				break
+			}
+		}
+
+		time.Sleep(5 * time.Millisecond)
+		return Response{Answers: []string{"192.0.2.1"}}, nil
+	}))
+
+	gather(t, collector)
+
+	if peak := maxInflight.Load(); peak > 3 {
+		t.Errorf("expected at most 3 lookups in flight, got %d", peak)
+	}
+}
+
+func TestCollectQueuedProbesTimeOut(t *testing.T) {
+	config := testConfig("a.example.org", "b.example.org", "c.example.org")
+	config.MaxConcurrency = 1
+	config.ScrapeTimeout = 50 * time.Millisecond
+
+	var lookups atomic.Int32
+	collector := newFakeCollector(t, config, fakeResolver(func(ctx context.Context, q Query) (Response, error) {
+		lookups.Add(1)
+		<-ctx.Done()
+		return Response{}, ctx.Err()
+	}))
+
+	families := gather(t, collector)
+
+	if lookups := lookups.Load(); lookups != 1 {
+		t.Errorf("expected only the probe holding the slot to be looked up, got %d lookups", lookups)
+	}
+	for _, host := range config.Hosts {
+		labels := map[string]string{"host": host.Name, "error_type": ErrorTypeTimeout}
+		if errors := metricValue(t, families, "dns_exporter_resolution_error_total", labels); errors != 1 {
+			t.Errorf("expected 1 timeout error for %s, got %v", host.Name, errors)
+		}
+	}
+}
+
+func TestCollectDuplicateHosts(t *testing.T) {
+	collector := newFakeCollector(t, testConfig("example.org", "google.com", "example.org"), answering("192.0.2.1"))
+
+	// Gathering fails on duplicate series, such as from probing a host twice.
+	families := gather(t, collector)
+
+	if total := metricValue(t, families, "dns_exporter_resolution_total", map[string]string{"host": "example.org"}); total != 1 {
+		t.Errorf("expected 1 resolution of the duplicated host, got %v", total)
+	}
+
+	collector.SetHosts([]HostConfig{{Name: "google.com"}, {Name: "google.com"}})
+	gather(t, collector)
+}
+
+func TestCollectRepeatedlyWithoutDuplicateSeries(t *testing.T) {
+	config := testConfig("example.org", "google.com")
+	config.RecordTypes = []string{RecordTypeA, RecordTypeAAAA, RecordTypeA}
+	config.Resolvers = []string{"192.0.2.53:53", "192.0.2.54:53", "192.0.2.53:53"}
+	collector := newFakeCollector(t, config, answering("192.0.2.1"))
+
+	registry := prometheus.NewPedanticRegistry()
+	if err := collector.Register(registry); err != nil {
+		t.Fatalf("could not register collector: %s", err)
+	}
+
+	// The pedantic registry fails to gather duplicate series.
+	for i := 1; i <= 5; i++ {
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatalf("gather %d: %s", i, err)
+		}
+
+		byName := map[string]*dto.MetricFamily{}
+		for _, family := range families {
+			byName[family.GetName()] = family
+		}
+		for _, host := range []string{"example.org", "google.com"} {
+			for _, labels := range []map[string]string{
+				{"host": host, "qtype": RecordTypeA, "resolver": "192.0.2.53:53"},
+				{"host": host, "qtype": RecordTypeAAAA, "resolver": "192.0.2.54:53"},
+			} {
+				if total := metricValue(t, byName, "dns_exporter_resolution_total", labels); total != float64(i) {
+					t.Errorf("gather %d: expected %d resolutions of %v, got %v", i, i, labels, total)
+				}
+			}
+		}
+	}
+}
+
+func TestCollectRecoversPanics(t *testing.T) {
+	collector := newFakeCollector(t, testConfig("panic.example.org", "example.org"), fakeResolver(func(ctx context.Context, q Query) (Response, error) {
+		if q.Host == "panic.example.org" {
+			panic("injected by test")
+		}
+		return Response{Answers: []string{"192.0.2.1"}}, nil
+	}))
+
+	families := gather(t, collector)
+
+	if panics := metricValue(t, families, "dns_exporter_collector_panics_total", nil); panics != 1 {
+		t.Errorf("expected 1 panic, got %v", panics)
+	}
+	if total := metricValue(t, families, "dns_exporter_resolution_total", map[string]string{"host": "example.org"}); total != 1 {
+		t.Errorf("expected the other host to be resolved, got %v resolutions", total)
+	}
+}
+
+func TestCollectLabelsNetwork(t *testing.T) {
+	config := testConfig("example.org")
+	config.RecordTypes = []string{RecordTypeIP, RecordTypeA, RecordTypeAAAA, RecordTypeMX}
+
+	families := gather(t, newFakeCollector(t, config, answering("192.0.2.1")))
+
+	for recordType, network := range map[string]string{RecordTypeIP: NetworkIP, RecordTypeA: NetworkIP4, RecordTypeAAAA: NetworkIP6, RecordTypeMX: ""} {
+		labels := map[string]string{"host": "example.org", "qtype": recordType, "network": network}
+		if total := metricValue(t, families, "dns_exporter_resolution_total", labels); total != 1 {
+			t.Errorf("expected 1 resolution labelled %v, got %v", labels, total)
+		}
+	}
+}
+
+func TestCollectNamespaceAndSubsystem(t *testing.T) {
+	namespace, subsystem := Namespace, Subsystem
+	t.Cleanup(func() { Namespace, Subsystem = namespace, subsystem })
+
+	tests := []struct {
+		namespace string
+		subsystem string
+		prefix    string
+	}{
+		{namespace: "dns_exporter", prefix: "dns_exporter_"},
+		{namespace: "tenant", prefix: "tenant_"},
+		{namespace: "tenant", subsystem: "dns", prefix: "tenant_dns_"},
+	}
+
+	for _, test := range tests {
+		Namespace, Subsystem = test.namespace, test.subsystem
+
+		families := gather(t, newFakeCollector(t, testConfig("example.org"), answering("192.0.2.1")))
+		if _, ok := families[test.prefix+"resolution_total"]; !ok {
+			t.Errorf("namespace '%s', subsystem '%s': expected a %sresolution_total metric", test.namespace, test.subsystem, test.prefix)
+		}
+		for name := range families {
+			if !strings.HasPrefix(name, test.prefix) {
+				t.Errorf("namespace '%s', subsystem '%s': unexpected metric %s", test.namespace, test.subsystem, name)
+			}
+		}
+	}
+}
+
+func TestResolveHostConcurrently(t *testing.T) {
+	config := testConfig("example.org", "google.com")
+	config.RecordTypes = []string{RecordTypeA, RecordTypeAAAA}
+
+	collector := newFakeCollector(t, config, fakeResolver(func(ctx context.Context, q Query) (Response, error) {
+		if q.RecordType == RecordTypeAAAA {
+			return Response{}, &net.DNSError{Err: "no such host", IsNotFound: true}
+		}
+		return Response{Answers: []string{"192.0.2.1"}}, nil
+	}))
+
+	keys := []probeKey{}
+	hosts := map[probeKey]HostConfig{}
+	for _, host := range collector.Hosts() {
+		for _, recordType := range config.RecordTypes {
+			key := collector.probeKeys(host, recordType, collector.hostResolvers(host))[0]
+			keys = append(keys, key)
+			hosts[key] = host
+		}
+	}
+
+	// Run with -race to check every key is probed concurrently safely.
+	const probes = 20
+	var wg sync.WaitGroup
+	for i := 0; i < probes; i++ {
+		for _, key := range keys {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				collectMetrics(func(ch chan<- prometheus.Metric) {
+					collector.resolveHost(context.Background(), ch, hosts[key], key)
+				})
+			}()
+		}
+	}
+	wg.Wait()
+
+	for _, key := range keys {
+		collector.totalCountMutex.Lock()
+		total := collector.totalCount[key]
+		collector.totalCountMutex.Unlock()
+		if total != probes {
+			t.Errorf("%s %s: expected a total of %d, got %d", key.host, key.recordType, probes, total)
+		}
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	first := newFakeCollector(t, testConfig("example.org"), answering("192.0.2.1"))
+	second := newFakeCollector(t, testConfig("google.com"), answering("192.0.2.1"))
+
+	registry := prometheus.NewPedanticRegistry()
+	for _, collector := range []*DNSCollector{first, first, second} {
+		if err := collector.Register(registry); err != nil {
+			t.Fatalf("could not register collector: %s", err)
+		}
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("could not gather metrics: %s", err)
+	}
+	hosts := map[string]bool{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, pair := range metric.GetLabel() {
+				if pair.GetName() == "host" {
+					hosts[pair.GetValue()] = true
+				}
+			}
+		}
+	}
+	if hosts["example.org"] {
+		t.Error("expected the first collector to have been replaced")
+	}
+	if !hosts["google.com"] {
+		t.Error("expected the second collector to be registered")
+	}
+}
+
+func TestCollectHostTimeouts(t *testing.T) {
+	config := testConfig()
+	config.Timeout = time.Second
+	config.Hosts = []HostConfig{
+		{Name: "default.example.org"},
+		{Name: "fast.example.org", Timeout: 50 * time.Millisecond},
+		{Name: "slow.example.org", Timeout: 5 * time.Second},
+	}
+
+	var mutex sync.Mutex
+	timeouts := map[string]time.Duration{}
+	collector := newFakeCollector(t, config, fakeResolver(func(ctx context.Context, q Query) (Response, error) {
+		deadline, _ := ctx.Deadline()
+		mutex.Lock()
+		timeouts[q.Host] = time.Until(deadline)
+		mutex.Unlock()
+		return Response{Answers: []string{"192.0.2.1"}}, nil
+	}))
+	gather(t, collector)
+
+	for host, expected := range map[string]time.Duration{
+		"default.example.org": time.Second,
+		"fast.example.org":    50 * time.Millisecond,
+		"slow.example.org":    5 * time.Second,
+	} {
+		timeout := timeouts[host]
+		if timeout > expected || timeout < expected-40*time.Millisecond {
+			t.Errorf("%s: expected a timeout of %s, got %s", host, expected, timeout)
+		}
+	}
+}
+
+// metricHost returns the host label of the metric, or empty if it has none.
+func metricHost(metric prometheus.Metric) string {
+	var m dto.Metric
+	if err := metric.Write(&m); err != nil {
+		return ""
+	}
+	for _, pair := range m.GetLabel() {
+		if pair.GetName() == "host" {
+			return pair.GetValue()
+		}
+	}
+
+	return ""
+}
+
+func TestCollectStreamsFastHostsFirst(t *testing.T) {
+	release := make(chan struct{})
+	collector := newFakeCollector(t, testConfig("fast.example.org", "slow.example.org"), fakeResolver(func(ctx context.Context, q Query) (Response, error) {
+		if q.Host == "slow.example.org" {
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return Response{}, ctx.Err()
+			}
+		}
+		return Response{Answers: []string{"192.0.2.1"}}, nil
+	}))
+
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		collector.Collect(ch)
+		close(done)
+	}()
+
+	// The slow host is held until the fast host's metrics have arrived, which
+	// would never happen if the metrics were only sent once every probe was
+	// done.
+	timeout := time.After(time.Second)
+	for released := false; !released; {
+		select {
+		case metric := <-ch:
+			switch metricHost(metric) {
+			case "slow.example.org":
+				t.Fatal("expected no metrics of the slow host before it was released")
+			case "fast.example.org":
+				close(release)
+				released = true
+			}
+		case <-timeout:
+			close(release)
+			t.Fatal("expected the fast host's metrics before the slow host completed")
+		}
+	}
+
+	slow := false
+drain:
+	for {
+		select {
+		case metric := <-ch:
+			slow = slow || metricHost(metric) == "slow.example.org"
+		case <-done:
+			break drain
+		}
+	}
+	if !slow {
+		t.Error("expected metrics of the slow host once it was released")
+	}
+}
+
+func TestCollectHostModes(t *testing.T) {
+	address := startDNSServer(t, answerA("192.0.2.1"))
+	endpoint := startDoHServer(t, func(query *dns.Msg) *dns.Msg {
+		msg := new(dns.Msg)
+		msg.SetReply(query)
+		rr, _ := dns.NewRR(query.Question[0].Name + " 60 IN A 192.0.2.2")
+		msg.Answer = append(msg.Answer, rr)
+		return msg
+	})
+
+	config := testConfig()
+	config.Resolver = address
+	config.Hosts = []HostConfig{
+		{Name: "stdlib.example.org"},
+		{Name: "raw.example.org", Mode: ModeRaw},
+		{Name: "doh.example.org", Mode: ModeDoH, Resolver: endpoint},
+	}
+	collector, err := NewDNSCollector(config)
+	if err != nil {
+		t.Fatalf("could not create dns collector: %s", err)
+	}
+
+	families := gather(t, collector)
+	for _, labels := range []map[string]string{
+		{"host": "stdlib.example.org", "mode": ModeStdlib, "resolver": address},
+		{"host": "raw.example.org", "mode": ModeRaw, "resolver": address},
+		{"host": "doh.example.org", "mode": ModeDoH, "resolver": endpoint},
+	} {
+		if success := metricValue(t, families, "dns_exporter_resolution_success", labels); success != 1 {
+			t.Errorf("%s: expected success 1, got %v", labels["host"], success)
+		}
+	}
+}
+
+func TestFailingIgnoresStaleProbes(t *testing.T) {
+	config := testConfig("example.org")
+	config.Resolvers = []string{"192.0.2.53:53", "192.0.2.54:53"}
+	config.ResolverStrategy = ResolverStrategyRoundRobin
+	collector := newFakeCollector(t, config, answering("192.0.2.1"))
+	collector.resolversMutex.Lock()
+	collector.resolvers[resolverKey{address: "192.0.2.53:53", mode: collector.mode}] = fakeResolver(func(ctx context.Context, q Query) (Response, error) {
+		return Response{}, &net.DNSError{Err: "no such host", IsNotFound: true}
+	})
+	collector.resolversMutex.Unlock()
+
+	if collector.Failing() {
+		t.Error("expected no failures before probing")
+	}
+
+	gather(t, collector)
+	if !collector.Failing() {
+		t.Error("expected the probe of the failing resolver to fail")
+	}
+
+	// The next scrape is rotated to the other resolver, which succeeds.
+	gather(t, collector)
+	if collector.Failing() {
+		t.Error("expected the failure of the resolver rotated away from not to count")
+	}
+
+	gather(t, collector)
+	if !collector.Failing() {
+		t.Error("expected the probe of the failing resolver to fail again")
+	}
+	disabled := false
+	collector.SetHosts([]HostConfig{{Name: "example.org", Enabled: &disabled}})
+	gather(t, collector)
+	if collector.Failing() {
+		t.Error("expected the failure of a disabled host not to count")
+	}
+}
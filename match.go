@@ -0,0 +1,279 @@
+package main
+
+import (
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	MatchExact     = "exact"
+	MatchSubstring = "substring"
+)
+
+// matchesExpected returns whether the answers contain every value expected of
+// the host for the record type, and whether any values are expected.
+func matchesExpected(host HostConfig, recordType string, answers []string) (bool, bool) {
+	if !recordTypeHasFamily(recordType, FamilyNone) {
+		expected := expectedIPs(host, recordType)
+		return containsAllIPs(answers, expected), len(expected) > 0
+	}
+
+	expected := host.Expected[recordType]
+	for _, value := range expected.Values {
+		found := slices.ContainsFunc(answers, func(answer string) bool {
+			return matchesValue(recordType, expected.Match, answer, value)
+		})
+		if !found {
+			return false, true
+		}
+	}
+
+	return true, len(expected.Values) > 0
+}
+
+// inExpectedCIDRs returns whether there are addresses in the answers and all
+// are within at least one of the host's expected CIDRs, and whether any are
+// expected for the record type.
+func inExpectedCIDRs(host HostConfig, recordType string, answers []string) (bool, bool) {
+	if recordTypeHasFamily(recordType, FamilyNone) || len(host.ExpectedCIDRs) == 0 {
+		return false, false
+	}
+
+	networks := []*net.IPNet{}
+	for _, cidr := range host.ExpectedCIDRs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			networks = append(networks, network)
+		}
+	}
+
+	for _, answer := range answers {
+		ip := net.ParseIP(answer)
+		within := slices.ContainsFunc(networks, func(network *net.IPNet) bool {
+			return ip != nil && network.Contains(ip)
+		})
+		if !within {
+			return false, true
+		}
+	}
+
+	return len(answers) > 0, true
+}
+
+func matchesValue(recordType, match, answer, value string) bool {
+	if recordType != RecordTypeTXT {
+		return strings.EqualFold(strings.TrimSuffix(answer, "."), strings.TrimSuffix(value, "."))
+	}
+
+	if match == MatchSubstring {
+		return strings.Contains(answer, value)
+	}
+	return answer == value
+}
+
+// expectedIPs returns the expected IPs of the host that can be returned by a
+// lookup of the given record type, so that A and AAAA lookups are only
+// matched against IPv4 and IPv6 addresses respectively.
+func expectedIPs(host HostConfig, recordType string) []net.IP {
+	ips := []net.IP{}
+
+	values := append(append([]string{}, host.ExpectedIPs...), host.Expected[recordType].Values...)
+	for _, expected := range values {
+		ip := net.ParseIP(expected)
+		if ip == nil {
+			continue
+		}
+
+		if recordTypeHasFamily(recordType, ipFamily(ip)) {
+			ips = append(ips, ip)
+		}
+	}
+
+	return ips
+}
+
+// ipDrift returns how many of the expected IPs of the host are missing from
+// the answers, and how many distinct addresses were answered that aren't
+// expected, and whether any IPs are expected for the record type.
+func ipDrift(host HostConfig, recordType string, answers []string) (int, int, bool) {
+	if recordTypeHasFamily(recordType, FamilyNone) {
+		return 0, 0, false
+	}
+	expected := expectedIPs(host, recordType)
+	if len(expected) == 0 {
+		return 0, 0, false
+	}
+
+	answered := []net.IP{}
+	for _, answer := range answers {
+		if ip := net.ParseIP(answer); ip != nil && !slices.ContainsFunc(answered, ip.Equal) {
+			answered = append(answered, ip)
+		}
+	}
+
+	missing := []net.IP{}
+	for _, ip := range expected {
+		if !slices.ContainsFunc(answered, ip.Equal) && !slices.ContainsFunc(missing, ip.Equal) {
+			missing = append(missing, ip)
+		}
+	}
+
+	unexpected := 0
+	for _, ip := range answered {
+		if !slices.ContainsFunc(expected, ip.Equal) {
+			unexpected++
+		}
+	}
+
+	return len(missing), unexpected, true
+}
+
+func containsAllIPs(answers []string, expected []net.IP) bool {
+	for _, ip := range expected {
+		found := false
+
+		for _, answer := range answers {
+			if ip.Equal(net.ParseIP(answer)) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+const (
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+	FamilyNone = "none"
+)
+
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return FamilyIPv4
+	}
+
+	return FamilyIPv6
+}
+
+// recordTypeFamilies returns the address families of the records returned by
+// a lookup of the given record type.
+func recordTypeFamilies(recordType string) []string {
+	switch recordType {
+	case RecordTypeIP:
+		return []string{FamilyIPv4, FamilyIPv6}
+	case RecordTypeA:
+		return []string{FamilyIPv4}
+	case RecordTypeAAAA:
+		return []string{FamilyIPv6}
+	default:
+		return []string{FamilyNone}
+	}
+}
+
+func recordTypeHasFamily(recordType, family string) bool {
+	for _, f := range recordTypeFamilies(recordType) {
+		if f == family {
+			return true
+		}
+	}
+
+	return false
+}
+
+// countByFamily counts answers by address family, with answers that are not
+// addresses counted under FamilyNone.
+func countByFamily(answers []string) map[string]int {
+	counts := map[string]int{}
+
+	for _, answer := range answers {
+		ip := net.ParseIP(answer)
+		if ip == nil {
+			counts[FamilyNone]++
+			continue
+		}
+
+		counts[ipFamily(ip)]++
+	}
+
+	return counts
+}
+
+// normalizeAnswers returns the answers in canonical form, sorted, so that
+// answers differing only in order or formatting compare equal. Addresses are
+// formatted as parsed IPs and names are lowercased without a trailing dot,
+// while other values, such as TXT, are kept exactly, as their case may be
+// significant.
+func normalizeAnswers(recordType string, answers []string) []string {
+	normalized := []string{}
+	for _, answer := range answers {
+		switch recordType {
+		case RecordTypeIP, RecordTypeA, RecordTypeAAAA:
+			if ip := net.ParseIP(answer); ip != nil {
+				answer = ip.String()
+			}
+		case RecordTypeCNAME, RecordTypeMX, RecordTypeNS, RecordTypeSRV, RecordTypePTR:
+			answer = strings.ToLower(strings.TrimSuffix(answer, "."))
+		}
+		normalized = append(normalized, answer)
+	}
+	sort.Strings(normalized)
+
+	return normalized
+}
+
+// sameAnswers returns whether a and b contain the same answers, in any order.
+func sameAnswers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	sortedA := append([]string{}, a...)
+	sortedB := append([]string{}, b...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+
+	for i := range sortedA {
+		if sortedA[i] != sortedB[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// recordCountOK returns whether the number of records is within the host's
+// expected range, which is always the case when unset.
+func recordCountOK(host HostConfig, count int) bool {
+	if count < host.MinRecords {
+		return false
+	}
+
+	return host.MaxRecords == 0 || count <= host.MaxRecords
+}
+
+// hasNonPublicIP returns whether any of the answers is a loopback, link-local,
+// private, or unspecified address.
+func hasNonPublicIP(answers []string) bool {
+	return slices.ContainsFunc(answers, func(answer string) bool {
+		ip := net.ParseIP(answer)
+		return ip != nil && (ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsPrivate() || ip.IsUnspecified())
+	})
+}
+
+// ttlOK returns whether the TTL, in seconds, is within the host's bounds.
+func ttlOK(host HostConfig, ttl uint32) bool {
+	d := time.Duration(ttl) * time.Second
+	if d < host.MinTTL {
+		return false
+	}
+
+	return host.MaxTTL == 0 || d <= host.MaxTTL
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestNormalizeAnswers(t *testing.T) {
+	tests := []struct {
+		recordType string
+		answers    []string
+		expected   []string
+	}{
+		{recordType: RecordTypeA, answers: []string{"192.0.2.2", "192.0.2.1"}, expected: []string{"192.0.2.1", "192.0.2.2"}},
+		{recordType: RecordTypeA, answers: []string{"::ffff:192.0.2.1"}, expected: []string{"192.0.2.1"}},
+		{recordType: RecordTypeAAAA, answers: []string{"2001:DB8:0:0::1"}, expected: []string{"2001:db8::1"}},
+		{recordType: RecordTypeIP, answers: []string{"2001:db8::1", "192.0.2.1"}, expected: []string{"192.0.2.1", "2001:db8::1"}},
+		{recordType: RecordTypeCNAME, answers: []string{"Target.Example.org."}, expected: []string{"target.example.org"}},
+		{recordType: RecordTypeMX, answers: []string{"mx2.example.org.", "MX1.example.org"}, expected: []string{"mx1.example.org", "mx2.example.org"}},
+		{recordType: RecordTypeNS, answers: []string{"NS1.example.org."}, expected: []string{"ns1.example.org"}},
+		{recordType: RecordTypeSRV, answers: []string{"Sip.example.org."}, expected: []string{"sip.example.org"}},
+		{recordType: RecordTypePTR, answers: []string{"Host.example.org."}, expected: []string{"host.example.org"}},
+		{recordType: RecordTypeTXT, answers: []string{"v=spf1 -all", "Case Kept."}, expected: []string{"Case Kept.", "v=spf1 -all"}},
+		{recordType: RecordTypeA, answers: nil, expected: []string{}},
+	}
+
+	for _, test := range tests {
+		if normalized := normalizeAnswers(test.recordType, test.answers); !slices.Equal(normalized, test.expected) {
+			t.Errorf("%s %v: expected %v, got %v", test.recordType, test.answers, test.expected, normalized)
+		}
+	}
+}
+
+func TestCollectChangesIgnoreFormatting(t *testing.T) {
+	responses := [][]string{
+		{"mx1.example.org.", "mx2.example.org."},
+		{"MX2.example.org", "mx1.example.org"},
+		{"mx1.example.org", "mx3.example.org"},
+	}
+
+	config := testConfig("example.org")
+	config.RecordTypes = []string{RecordTypeMX}
+	probes := 0
+	collector := newFakeCollector(t, config, fakeResolver(func(ctx context.Context, q Query) (Response, error) {
+		answers := responses[probes]
+		probes++
+		return Response{Answers: answers}, nil
+	}))
+
+	for i, expected := range []float64{0, 0, 1} {
+		families := gather(t, collector)
+		if changes := metricValue(t, families, "dns_exporter_resolution_changes_total", map[string]string{"host": "example.org"}); changes != expected {
+			t.Errorf("scrape %d: expected %v changes, got %v", i, expected, changes)
+		}
+	}
+}
+
+func TestTTLOK(t *testing.T) {
+	tests := []struct {
+		minTTL, maxTTL time.Duration
+		ttl            uint32
+		ok             bool
+	}{
+		{ttl: 0, ok: true},
+		{minTTL: time.Minute, ttl: 59, ok: false},
+		{minTTL: time.Minute, ttl: 60, ok: true},
+		{maxTTL: time.Hour, ttl: 3600, ok: true},
+		{maxTTL: time.Hour, ttl: 3601, ok: false},
+		{minTTL: time.Minute, maxTTL: time.Hour, ttl: 300, ok: true},
+		{minTTL: time.Minute, maxTTL: time.Hour, ttl: 30, ok: false},
+	}
+
+	for _, test := range tests {
+		host := HostConfig{Name: "example.org", MinTTL: test.minTTL, MaxTTL: test.maxTTL}
+		if ok := ttlOK(host, test.ttl); ok != test.ok {
+			t.Errorf("ttl %d within [%s, %s]: expected %t, got %t", test.ttl, test.minTTL, test.maxTTL, test.ok, ok)
+		}
+	}
+}
+
+func TestCollectTTLOK(t *testing.T) {
+	config := testConfig()
+	config.Hosts = []HostConfig{
+		{Name: "unbounded.example.org"},
+		{Name: "short.example.org", MinTTL: time.Minute},
+		{Name: "long.example.org", MinTTL: 10 * time.Second, MaxTTL: time.Minute},
+	}
+	collector := newFakeCollector(t, config, fakeResolver(func(ctx context.Context, q Query) (Response, error) {
+		msg := new(dns.Msg)
+		msg.SetQuestion(dns.Fqdn(q.Host), dns.TypeA)
+		rr, _ := dns.NewRR(dns.Fqdn(q.Host) + " 30 IN A 192.0.2.1")
+		msg.Answer = append(msg.Answer, rr)
+		return Response{Answers: []string{"192.0.2.1"}, Msg: msg}, nil
+	}))
+
+	families := gather(t, collector)
+	if _, ok := findMetric(families["dns_exporter_resolution_ttl_ok"], map[string]string{"host": "unbounded.example.org"}); ok {
+		t.Error("expected no ttl_ok metric of a host without ttl bounds")
+	}
+	for host, expected := range map[string]float64{"short.example.org": 0, "long.example.org": 1} {
+		if ok := metricValue(t, families, "dns_exporter_resolution_ttl_ok", map[string]string{"host": host}); ok != expected {
+			t.Errorf("%s: expected ttl_ok %v, got %v", host, expected, ok)
+		}
+	}
+}
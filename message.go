@@ -0,0 +1,331 @@
+package main
+
+import (
+	"cmp"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// messageQtype returns the query type of the record type, which for resolvers
+// constructing DNS messages may be any type known to miekg/dns, such as ANY or
+// HTTPS, or a numeric type.
+func messageQtype(recordType string) (uint16, bool) {
+	if qtype, ok := dns.StringToType[recordType]; ok {
+		return qtype, true
+	}
+
+	qtype, err := strconv.ParseUint(recordType, 10, 16)
+	return uint16(qtype), err == nil
+}
+
+// messageQclass returns the query class of the class name, defaulting to IN.
+func messageQclass(class string) (uint16, bool) {
+	if class == "" {
+		return dns.ClassINET, true
+	}
+
+	qclass, ok := dns.StringToClass[class]
+	return qclass, ok
+}
+
+// queryFlags are the header flags of a query that can be changed, their zero
+// value being the default of recursion desired and checking enabled.
+type queryFlags struct {
+	noRecursion      bool
+	checkingDisabled bool
+}
+
+// String returns the flags set, as labelled, such as "rd" by default.
+func (f queryFlags) String() string {
+	flags := []string{}
+	if !f.noRecursion {
+		flags = append(flags, "rd")
+	}
+	if f.checkingDisabled {
+		flags = append(flags, "cd")
+	}
+
+	return strings.Join(flags, ",")
+}
+
+func newQuery(q Query) (*dns.Msg, error) {
+	host, recordType := q.Host, q.RecordType
+
+	if recordType == RecordTypeSRV {
+		if err := validateSRVName(host); err != nil {
+			return nil, err
+		}
+	}
+
+	qtype, ok := messageQtype(recordType)
+	if !ok {
+		return nil, fmt.Errorf("unsupported record type '%s'", recordType)
+	}
+
+	name := dns.Fqdn(host)
+	if recordType == RecordTypePTR {
+		var err error
+		name, err = dns.ReverseAddr(host)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	qclass, ok := messageQclass(q.Class)
+	if !ok {
+		return nil, fmt.Errorf("unsupported class '%s'", q.Class)
+	}
+
+	if q.RandomizeCase {
+		name = randomizeCase(name)
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.Question[0].Qclass = qclass
+	msg.RecursionDesired = !q.NoRecursion
+	msg.CheckingDisabled = q.CheckingDisabled
+
+	if q.DNSSEC {
+		msg.AuthenticatedData = true
+		setEDNS0(msg, DefaultEDNSBufferSize, true)
+	}
+
+	if q.NSID {
+		setEDNS0(msg, DefaultEDNSBufferSize, q.DNSSEC)
+		opt := msg.IsEdns0()
+		opt.Option = append(opt.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+	}
+
+	if q.ECS != "" {
+		subnet, err := clientSubnet(q.ECS)
+		if err != nil {
+			return nil, err
+		}
+		setEDNS0(msg, DefaultEDNSBufferSize, q.DNSSEC)
+		opt := msg.IsEdns0()
+		opt.Option = append(opt.Option, subnet)
+	}
+
+	return msg, nil
+}
+
+// randomizeCase returns the name with the case of each letter chosen at
+// random.
+func randomizeCase(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+			if rand.Intn(2) == 0 {
+				b[i] = c | 0x20
+			} else {
+				b[i] = c &^ 0x20
+			}
+		}
+	}
+
+	return string(b)
+}
+
+// caseMismatch returns whether the response doesn't echo the query name in
+// exactly the same case.
+func caseMismatch(query, msg *dns.Msg) bool {
+	return len(msg.Question) == 0 || msg.Question[0].Name != query.Question[0].Name
+}
+
+// clientSubnet returns the EDNS Client Subnet option for the CIDR.
+func clientSubnet(cidr string) (*dns.EDNS0_SUBNET, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	ones, _ := network.Mask.Size()
+	subnet := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		SourceNetmask: uint8(ones),
+		Address:       network.IP,
+	}
+	if ipFamily(network.IP) == FamilyIPv4 {
+		subnet.Family = 1
+	} else {
+		subnet.Family = 2
+	}
+
+	return subnet, nil
+}
+
+// setEDNS0 sets the EDNS0 buffer size and DO bit, updating any existing OPT
+// record rather than adding another.
+func setEDNS0(msg *dns.Msg, size uint16, do bool) {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		msg.SetEdns0(size, do)
+		return
+	}
+
+	opt.SetUDPSize(size)
+	if do {
+		opt.SetDo()
+	}
+}
+
+// dnssecValidated returns whether the response has the AD bit set.
+func dnssecValidated(msg *dns.Msg) bool {
+	return msg.AuthenticatedData
+}
+
+// responseFromMsg returns the response to the query, which the message must
+// already have been checked to match.
+func responseFromMsg(host string, query, msg *dns.Msg) (Response, error) {
+	answers, err := answersFromMsg(host, query.Question[0].Qtype, msg)
+	return Response{Answers: answers, SRV: srvFromMsg(msg), Msg: msg}, err
+}
+
+// responseError is a lookup error classified from the DNS response.
+type responseError struct {
+	errorType string
+	err       error
+}
+
+func (e *responseError) Error() string {
+	return e.err.Error()
+}
+
+func (e *responseError) Unwrap() error {
+	return e.err
+}
+
+func answersFromMsg(host string, qtype uint16, msg *dns.Msg) ([]string, error) {
+	switch msg.Rcode {
+	case dns.RcodeSuccess:
+	case dns.RcodeNameError:
+		return nil, &responseError{errorType: ErrorTypeNXDomain, err: &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}}
+	case dns.RcodeServerFailure:
+		return nil, &net.DNSError{Err: "server misbehaving", Name: host, IsTemporary: true}
+	default:
+		return nil, &net.DNSError{Err: dns.RcodeToString[msg.Rcode], Name: host}
+	}
+
+	answers := []string{}
+	for _, rr := range msg.Answer {
+		if rr.Header().Rrtype != qtype && qtype != dns.TypeANY {
+			continue
+		}
+
+		switch rr := rr.(type) {
+		case *dns.A:
+			answers = append(answers, rr.A.String())
+		case *dns.AAAA:
+			answers = append(answers, rr.AAAA.String())
+		case *dns.CNAME:
+			answers = append(answers, rr.Target)
+		case *dns.MX:
+			answers = append(answers, rr.Mx)
+		case *dns.NS:
+			answers = append(answers, rr.Ns)
+		case *dns.TXT:
+			answers = append(answers, strings.Join(rr.Txt, ""))
+		case *dns.SRV:
+			answers = append(answers, rr.Target)
+		case *dns.PTR:
+			answers = append(answers, rr.Ptr)
+		default:
+			answers = append(answers, strings.TrimPrefix(rr.String(), rr.Header().String()))
+		}
+	}
+
+	if len(answers) == 0 && (qtype == dns.TypeA || qtype == dns.TypeAAAA) && hasCNAME(msg) {
+		return nil, &responseError{errorType: ErrorTypeCNAMENoAddress, err: &net.DNSError{Err: "cname with no address records", Name: host, IsNotFound: true}}
+	}
+	if len(answers) == 0 {
+		return nil, &responseError{errorType: ErrorTypeNoData, err: &net.DNSError{Err: "no records of the requested type", Name: host, IsNotFound: true}}
+	}
+
+	return answers, nil
+}
+
+// hasCNAME returns whether the answer section of the message has a CNAME.
+func hasCNAME(msg *dns.Msg) bool {
+	return slices.ContainsFunc(msg.Answer, func(rr dns.RR) bool {
+		return rr.Header().Rrtype == dns.TypeCNAME
+	})
+}
+
+func srvFromMsg(msg *dns.Msg) []*net.SRV {
+	srvs := []*net.SRV{}
+	for _, rr := range msg.Answer {
+		if srv, ok := rr.(*dns.SRV); ok {
+			srvs = append(srvs, &net.SRV{
+				Target:   srv.Target,
+				Port:     srv.Port,
+				Priority: srv.Priority,
+				Weight:   srv.Weight,
+			})
+		}
+	}
+
+	return srvs
+}
+
+// compareSRV orders SRV records by preference, the lowest priority and then
+// highest weight first.
+func compareSRV(a, b *net.SRV) int {
+	return cmp.Or(
+		cmp.Compare(a.Priority, b.Priority),
+		cmp.Compare(b.Weight, a.Weight),
+		strings.Compare(a.Target, b.Target),
+		cmp.Compare(a.Port, b.Port),
+	)
+}
+
+// nsid returns the NSID of the server that answered, or empty if it wasn't
+// returned or isn't a short printable identifier.
+func nsid(msg *dns.Msg) string {
+	if msg == nil || msg.IsEdns0() == nil {
+		return ""
+	}
+
+	for _, option := range msg.IsEdns0().Option {
+		option, ok := option.(*dns.EDNS0_NSID)
+		if !ok {
+			continue
+		}
+
+		id, err := hex.DecodeString(option.Nsid)
+		if err != nil || len(id) == 0 || len(id) > 64 {
+			return ""
+		}
+		for _, b := range id {
+			if b < '!' || b > '~' {
+				return ""
+			}
+		}
+		return string(id)
+	}
+
+	return ""
+}
+
+// authorities returns the nameservers in the authority section of the message.
+func authorities(msg *dns.Msg) []string {
+	if msg == nil {
+		return nil
+	}
+
+	nameservers := []string{}
+	for _, rr := range msg.Ns {
+		if ns, ok := rr.(*dns.NS); ok {
+			nameservers = append(nameservers, ns.Ns)
+		}
+	}
+
+	return nameservers
+}
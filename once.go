@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// runOnce probes every host once, writes the metrics to w in the text
+// exposition format, pushes them too if push is set, and returns whether any
+// resolution failed.
+func runOnce(w io.Writer, push func(prometheus.Gatherer) error, collectors ...prometheus.Collector) (bool, error) {
+	registry := prometheus.NewRegistry()
+	for _, collector := range collectors {
+		if err := registry.Register(collector); err != nil {
+			return false, fmt.Errorf("could not register collector: %s", err)
+		}
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		return false, fmt.Errorf("could not gather metrics: %s", err)
+	}
+
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(w, family); err != nil {
+			return false, fmt.Errorf("could not write metrics: %s", err)
+		}
+	}
+
+	if push != nil {
+		gathered := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return families, nil
+		})
+		if err := push(gathered); err != nil {
+			return false, fmt.Errorf("could not push metrics: %s", err)
+		}
+	}
+
+	return hasErrors(families), nil
+}
+
+func hasErrors(families []*dto.MetricFamily) bool {
+	name := prometheus.BuildFQName(Namespace, Subsystem, "resolution_error_total")
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			if metric.GetCounter().GetValue() > 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
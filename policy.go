@@ -0,0 +1,92 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	PolicySPF   = "spf"
+	PolicyDMARC = "dmarc"
+)
+
+var (
+	spfMechanism = regexp.MustCompile(`(?i)^[+\-~?]?(all|include:\S+|a(:[^/\s]+)?(/\d+)?(//\d+)?|mx(:[^/\s]+)?(/\d+)?(//\d+)?|ptr(:\S+)?|ip4:[0-9./]+|ip6:[0-9a-f:./]+|exists:\S+)$`)
+	spfModifier  = regexp.MustCompile(`(?i)^[a-z][a-z0-9\-_.]*=\S*$`)
+)
+
+// policyRecords returns the TXT answers that are records of the policy, which
+// begin with its version tag.
+func policyRecords(policy string, answers []string) []string {
+	records := []string{}
+	for _, answer := range answers {
+		var version string
+		switch policy {
+		case PolicySPF:
+			version, _, _ = strings.Cut(answer, " ")
+			if strings.EqualFold(version, "v=spf1") {
+				records = append(records, answer)
+			}
+		case PolicyDMARC:
+			version, _, _ = strings.Cut(answer, ";")
+			if strings.TrimSpace(version) == "v=DMARC1" {
+				records = append(records, answer)
+			}
+		}
+	}
+
+	return records
+}
+
+// validPolicy returns whether the records are a single well-formed record of
+// the policy, as more than one is an error for both SPF and DMARC.
+func validPolicy(policy string, records []string) bool {
+	if len(records) != 1 {
+		return false
+	}
+
+	switch policy {
+	case PolicySPF:
+		return validSPF(records[0])
+	case PolicyDMARC:
+		return validDMARC(records[0])
+	}
+	return false
+}
+
+func validSPF(record string) bool {
+	for _, term := range strings.Fields(record)[1:] {
+		if !spfMechanism.MatchString(term) && !spfModifier.MatchString(term) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validDMARC(record string) bool {
+	tags := map[string]string{}
+	for i, tag := range strings.Split(record, ";") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(tag, "=")
+		if !ok {
+			return false
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if i > 0 && name == "v" {
+			return false
+		}
+		tags[name] = value
+	}
+
+	switch tags["p"] {
+	case "none", "quarantine", "reject":
+		return true
+	default:
+		return false
+	}
+}
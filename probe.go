@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"golang.org/x/time/rate"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// registerProbe registers a collector probing only the host, sharing the
+// resolvers of the shared collector if set.
+func registerProbe(registry *prometheus.Registry, config Config, host HostConfig, shared *DNSCollector) error {
+	config.Hosts = []HostConfig{host}
+	config.FileSD = nil
+	config.Listeners = nil
+	config.ProbeInterval = 0
+	config.WebhookURL = ""
+
+	dnsCollector, err := newDNSCollector(config, shared)
+	if err != nil {
+		return fmt.Errorf("could not create dns collector: %s", err)
+	}
+
+	return dnsCollector.Register(registry)
+}
+
+func compileTargetPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := []*regexp.Regexp{}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("probe allowed target '%s' is not a valid regular expression: %s", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+
+	return compiled, nil
+}
+
+func targetAllowed(patterns []*regexp.Regexp, target string) bool {
+	for _, re := range patterns {
+		if re.MatchString(target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// probeHandler probes the target given in the request, if it is allowed by
+// the configured probe_allowed_targets, so that the exporter cannot be used
+// to resolve arbitrary names. Requests beyond rateLimit per second across all
+// targets are rejected, unless rateLimit is zero. Probes reuse the resolvers
+// of the collector, and are served with the handler options of /metrics.
+func probeHandler(config Config, collector *DNSCollector, opts promhttp.HandlerOpts, rateLimit float64) (http.HandlerFunc, error) {
+	allowed, err := compileTargetPatterns(config.ProbeAllowedTargets)
+	if err != nil {
+		return nil, err
+	}
+
+	limiter := rate.NewLimiter(rate.Inf, 0)
+	if rateLimit > 0 {
+		limiter = rate.NewLimiter(rate.Limit(rateLimit), max(1, int(rateLimit)))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !limiter.Allow() {
+			http.Error(w, "probe rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "target parameter is missing", http.StatusBadRequest)
+			return
+		}
+		if !targetAllowed(allowed, target) {
+			http.Error(w, "target is not allowed", http.StatusForbidden)
+			return
+		}
+
+		host, err := HostConfig{Name: target}.withDefaults(config.Defaults).toASCII()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		registry := prometheus.NewRegistry()
+		if err := registerProbe(registry, config, host, collector); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		promhttp.HandlerFor(registry, opts).ServeHTTP(w, r)
+	}, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestRegisterProbeWithListeners(t *testing.T) {
+	config := DefaultConfig()
+	config.Listeners = []ListenerConfig{{Address: ":9999", Hosts: []string{"example.org"}}}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("unexpected invalid config: %s", err)
+	}
+
+	if err := registerProbe(prometheus.NewRegistry(), config, HostConfig{Name: "example.com"}.withDefaults(config.Defaults), nil); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestRegisterProbeSharesResolvers(t *testing.T) {
+	config := testConfig("example.org")
+	config.Mode = ModeDoH
+	config.Resolver = "https://192.0.2.53/dns-query"
+	shared := newFakeCollector(t, config, answering("192.0.2.1"))
+
+	registry := prometheus.NewPedanticRegistry()
+	if err := registerProbe(registry, config, HostConfig{Name: "probed.example.org"}.withDefaults(config.Defaults), shared); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The shared resolver is the fake, so the probe only succeeds with it.
+	if success := metricValue(t, gather(t, registry), "dns_exporter_resolution_success", map[string]string{"host": "probed.example.org"}); success != 1 {
+		t.Errorf("expected the probe to use the shared resolver, got success %v", success)
+	}
+}
+
+func TestTargetAllowed(t *testing.T) {
+	patterns, err := compileTargetPatterns([]string{`.*\.example\.org`, "example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		target  string
+		allowed bool
+	}{
+		{target: "www.example.org", allowed: true},
+		{target: "example.com", allowed: true},
+		{target: "example.org", allowed: false},
+		{target: "www.example.com", allowed: false},
+		{target: "example.com.evil.org", allowed: false},
+	}
+
+	for _, test := range tests {
+		if allowed := targetAllowed(patterns, test.target); allowed != test.allowed {
+			t.Errorf("target '%s': expected allowed %t, got %t", test.target, test.allowed, allowed)
+		}
+	}
+}
+
+func TestProbeHandlerOpenMetrics(t *testing.T) {
+	config := testConfig("example.com")
+	config.Resolver = startDNSServer(t, answerA("192.0.2.1"))
+	config.ProbeAllowedTargets = []string{"example.org"}
+	config.Exemplars = true
+
+	collector, err := NewDNSCollector(config)
+	if err != nil {
+		t.Fatalf("could not create dns collector: %s", err)
+	}
+	handler, err := probeHandler(config, collector, promhttp.HandlerOpts{EnableOpenMetrics: true}, 0)
+	if err != nil {
+		t.Fatalf("could not create probe handler: %s", err)
+	}
+
+	tests := []struct {
+		accept      string
+		contentType string
+		exemplars   bool
+	}{
+		{accept: "application/openmetrics-text; version=1.0.0", contentType: "application/openmetrics-text", exemplars: true},
+		{accept: "text/plain", contentType: "text/plain", exemplars: false},
+		{accept: "", contentType: "text/plain", exemplars: false},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/probe?target=example.org", nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("accept '%s': expected status 200, got %d", test.accept, rec.Code)
+		}
+		if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, test.contentType) {
+			t.Errorf("accept '%s': expected content type %s, got %s", test.accept, test.contentType, contentType)
+		}
+		if exemplars := strings.Contains(rec.Body.String(), "trace_id"); exemplars != test.exemplars {
+			t.Errorf("accept '%s': expected exemplars %t, got %t", test.accept, test.exemplars, exemplars)
+		}
+	}
+}
+
+func TestProbeHandlerInternationalizedTarget(t *testing.T) {
+	config := testConfig("example.org")
+	config.Mode = ModeDoH
+	config.Resolver = "https://192.0.2.53/dns-query"
+	config.ProbeAllowedTargets = []string{`.*\.example`}
+
+	var queried string
+	collector := newFakeCollector(t, config, fakeResolver(func(ctx context.Context, q Query) (Response, error) {
+		queried = q.Host
+		return Response{Answers: []string{"192.0.2.1"}}, nil
+	}))
+	handler, err := probeHandler(config, collector, promhttp.HandlerOpts{}, 0)
+	if err != nil {
+		t.Fatalf("could not create probe handler: %s", err)
+	}
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/probe?target="+url.QueryEscape("bücher.example"), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if queried != "xn--bcher-kva.example" {
+		t.Errorf("expected query for xn--bcher-kva.example, got '%s'", queried)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `display_name="bücher.example"`) {
+		t.Errorf("expected display_name label of the target, got:\n%s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/probe?target="+url.QueryEscape("bü_cher.example"), nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid target, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// contextDialer dials connections to resolvers, either directly or through a
+// proxy.
+type contextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// sourceDialer dials connections from a local source address, or any if the
+// source is nil, within the timeout.
+type sourceDialer struct {
+	source  net.IP
+	timeout time.Duration
+}
+
+func (d sourceDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: d.timeout}
+	if d.source != nil {
+		if strings.HasPrefix(network, "udp") {
+			dialer.LocalAddr = &net.UDPAddr{IP: d.source}
+		} else {
+			dialer.LocalAddr = &net.TCPAddr{IP: d.source}
+		}
+	}
+
+	return dialer.DialContext(ctx, network, address)
+}
+
+func (d sourceDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// newDialer returns a dialer connecting within the timeout from the given
+// source address, if set, and through the SOCKS5 proxy at the given
+// host:port, or directly if it is empty. SOCKS5 only proxies TCP, so the
+// dialer must not be used for UDP with a proxy.
+func newDialer(socks5Proxy, source string, timeout time.Duration) (contextDialer, error) {
+	forward := sourceDialer{source: net.ParseIP(source), timeout: timeout}
+	if socks5Proxy == "" {
+		return forward, nil
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", socks5Proxy, nil, forward)
+	if err != nil {
+		return nil, fmt.Errorf("could not create socks5 dialer: %s", err)
+	}
+
+	contextDialer, ok := dialer.(contextDialer)
+	if !ok {
+		return nil, fmt.Errorf("socks5 dialer does not support contexts")
+	}
+
+	return contextDialer, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	DefaultPushJob      = "dns_exporter"
+	DefaultPushInterval = time.Minute
+)
+
+// parseGroupingLabels parses comma-separated key=value pairs of grouping
+// labels to push metrics with.
+func parseGroupingLabels(s string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !model.LabelName(name).IsValid() {
+			return nil, fmt.Errorf("grouping label '%s' is not a valid name=value pair", pair)
+		}
+		labels[name] = strings.TrimSpace(value)
+	}
+
+	return labels, nil
+}
+
+// newPusher returns a pusher of the gathered metrics to the Pushgateway at
+// the URL, under the job and grouping labels.
+func newPusher(url, job string, grouping map[string]string, gatherer prometheus.Gatherer) *push.Pusher {
+	pusher := push.New(url, job).Gatherer(gatherer)
+	for name, value := range grouping {
+		pusher = pusher.Grouping(name, value)
+	}
+
+	return pusher
+}
+
+// pushMetrics pushes the metrics at the interval until the context is done,
+// replacing those previously pushed. As the metrics are gathered for every
+// push, hosts are probed for each, unless probing in the background.
+func pushMetrics(ctx context.Context, pusher *push.Pusher, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := pusher.PushContext(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("could not push metrics", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
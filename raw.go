@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	resolvConfPath = "/etc/resolv.conf"
+)
+
+// rawResolver queries a resolver directly with miekg/dns, exposing details
+// of the response that the stdlib resolver hides.
+type rawResolver struct {
+	address   string
+	client    *dns.Client
+	tcpClient *dns.Client
+	dialer    contextDialer
+
+	ednsBufferSize uint16
+}
+
+func newRawResolver(address string, options resolverOptions) (*rawResolver, error) {
+	if address == SystemResolver {
+		var err error
+		address, err = systemNameserver()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &rawResolver{
+		address:   address,
+		client:    &dns.Client{Net: options.protocol},
+		tcpClient: &dns.Client{Net: ProtocolTCP},
+		dialer:    options.dialer,
+
+		ednsBufferSize: options.ednsBufferSize,
+	}, nil
+}
+
+func systemNameserver() (string, error) {
+	servers, err := systemNameservers()
+	if err != nil {
+		return "", err
+	}
+	if len(servers) == 0 {
+		return "", fmt.Errorf("no nameservers configured in %s", resolvConfPath)
+	}
+
+	return servers[0], nil
+}
+
+// systemNameservers returns the addresses of the nameservers configured in
+// resolv.conf, in order, without duplicates.
+func systemNameservers() ([]string, error) {
+	config, err := dns.ClientConfigFromFile(resolvConfPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read system nameservers: %s", err)
+	}
+
+	servers := []string{}
+	for _, server := range config.Servers {
+		server = net.JoinHostPort(server, config.Port)
+		if !slices.Contains(servers, server) {
+			servers = append(servers, server)
+		}
+	}
+
+	return servers, nil
+}
+
+func (r *rawResolver) Lookup(ctx context.Context, q Query) (Response, error) {
+	if q.RecordType == RecordTypeIP {
+		return lookupBothFamilies(ctx, r, q)
+	}
+
+	query, err := newQuery(q)
+	if err != nil {
+		return Response{}, err
+	}
+	setEDNS0(query, r.ednsBufferSize, q.DNSSEC)
+
+	msg, connectDuration, queryDuration, err := r.exchange(ctx, r.client, query)
+	if err != nil {
+		return Response{}, err
+	}
+
+	// A truncated UDP response means the answer didn't fit, so the query is
+	// retried over TCP. This is part of the lookup rather than a retry, so
+	// is always done and doesn't count towards the configured retries.
+	truncated := msg.Truncated && r.client.Net == ProtocolUDP
+	if truncated {
+		var tcpConnectDuration, tcpQueryDuration time.Duration
+		msg, tcpConnectDuration, tcpQueryDuration, err = r.exchange(ctx, r.tcpClient, query)
+		if err != nil {
+			return Response{Truncated: true}, err
+		}
+		connectDuration += tcpConnectDuration
+		queryDuration += tcpQueryDuration
+	}
+
+	resp, err := responseFromMsg(q.Host, query, msg)
+	resp.Truncated = truncated
+	resp.CaseMismatch = q.RandomizeCase && caseMismatch(query, msg)
+	resp.QueryBytes = query.Len()
+	resp.ResponseBytes = msg.Len()
+	resp.ConnectDuration = connectDuration
+	resp.QueryDuration = queryDuration
+	resp.Server = r.address
+	return resp, err
+}
+
+// exchange sends the query with the client, dialling with the resolver's
+// dialer so that TCP connections can go through a proxy, and returns the
+// time taken to connect and to exchange the messages.
+func (r *rawResolver) exchange(ctx context.Context, client *dns.Client, query *dns.Msg) (*dns.Msg, time.Duration, time.Duration, error) {
+	start := time.Now()
+	conn, err := r.dialer.DialContext(ctx, client.Net, r.address)
+	if err != nil {
+		return nil, time.Since(start), 0, err
+	}
+	defer conn.Close()
+	connected := time.Now()
+
+	msg, err := exchangeWithConn(ctx, client, query, conn)
+	return msg, connected.Sub(start), time.Since(connected), err
+}
+
+// exchangeWithConn sends the query over the connection and returns the
+// response, failing with errSpoofed if it doesn't match the query. Unlike
+// miekg/dns, which skips UDP responses with mismatched IDs, the response is
+// never retried, so that each mismatch is reported.
+func exchangeWithConn(ctx context.Context, client *dns.Client, query *dns.Msg, conn net.Conn) (*dns.Msg, error) {
+	co := &dns.Conn{Conn: conn}
+	if client.Net != ProtocolUDP {
+		msg, _, err := client.ExchangeWithConnContext(ctx, query, co)
+		if errors.Is(err, dns.ErrId) {
+			return nil, errSpoofed
+		}
+		if err == nil && !matchesQuery(query, msg) {
+			return nil, errSpoofed
+		}
+		return msg, err
+	}
+
+	if opt := query.IsEdns0(); opt != nil && opt.UDPSize() >= dns.MinMsgSize {
+		co.UDPSize = opt.UDPSize()
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := co.WriteMsg(query); err != nil {
+		return nil, err
+	}
+	msg, err := co.ReadMsg()
+	if err != nil {
+		return nil, err
+	}
+	if !matchesQuery(query, msg) {
+		return nil, errSpoofed
+	}
+
+	return msg, nil
+}
+
+// errSpoofed is returned for responses whose ID or question don't match the
+// query, which may have been spoofed or come from a misbehaving resolver.
+var errSpoofed = errors.New("response does not match query")
+
+func matchesQuery(query, msg *dns.Msg) bool {
+	if msg.Id != query.Id || len(msg.Question) != len(query.Question) {
+		return false
+	}
+
+	for i, question := range query.Question {
+		if !strings.EqualFold(msg.Question[i].Name, question.Name) || msg.Question[i].Qtype != question.Qtype || msg.Question[i].Qclass != question.Qclass {
+			return false
+		}
+	}
+
+	return true
+}
+
+// minTTL returns the minimum TTL across the answers in the message, and
+// false if there are none.
+func minTTL(msg *dns.Msg) (uint32, bool) {
+	if msg == nil || len(msg.Answer) == 0 {
+		return 0, false
+	}
+
+	ttl := msg.Answer[0].Header().Ttl
+	for _, rr := range msg.Answer[1:] {
+		if rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+
+	return ttl, true
+}
+
+// rrsigExpiry returns when the soonest expiring RRSIG in the answers
+// expires, and false if there are none.
+func rrsigExpiry(msg *dns.Msg) (time.Time, bool) {
+	if msg == nil {
+		return time.Time{}, false
+	}
+
+	var expiry time.Time
+	for _, rr := range msg.Answer {
+		rrsig, ok := rr.(*dns.RRSIG)
+		if !ok {
+			continue
+		}
+
+		expiration := time.Unix(int64(rrsig.Expiration), 0)
+		if expiry.IsZero() || expiration.Before(expiry) {
+			expiry = expiration
+		}
+	}
+
+	return expiry, !expiry.IsZero()
+}
+
+const (
+	maxCNAMEDepth = 16
+)
+
+// answerRecordTypes counts the records in the answer section of the message by
+// type, and returns false if there is no message.
+func answerRecordTypes(msg *dns.Msg) (map[string]int, bool) {
+	if msg == nil {
+		return nil, false
+	}
+
+	counts := map[string]int{}
+	for _, rr := range msg.Answer {
+		counts[dns.Type(rr.Header().Rrtype).String()] += 1
+	}
+
+	return counts, true
+}
+
+// cnameDepth follows the CNAME chain in the answers from the query name and
+// returns the number of CNAMEs in it, capped at maxCNAMEDepth to guard
+// against loops.
+func cnameDepth(msg *dns.Msg) (int, bool) {
+	if msg == nil || len(msg.Question) == 0 {
+		return 0, false
+	}
+
+	cnames := map[string]string{}
+	for _, rr := range msg.Answer {
+		if cname, ok := rr.(*dns.CNAME); ok {
+			cnames[strings.ToLower(cname.Hdr.Name)] = cname.Target
+		}
+	}
+
+	depth := 0
+	name := msg.Question[0].Name
+	for depth < maxCNAMEDepth {
+		target, ok := cnames[strings.ToLower(name)]
+		if !ok {
+			break
+		}
+
+		name = target
+		depth++
+	}
+
+	return depth, true
+}
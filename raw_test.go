@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// newTestRawResolver returns a raw resolver querying the address over the
+// protocol.
+func newTestRawResolver(t *testing.T, address, protocol string) *rawResolver {
+	t.Helper()
+
+	resolver, err := newRawResolver(address, resolverOptions{
+		protocol:       protocol,
+		dialer:         sourceDialer{timeout: time.Second},
+		ednsBufferSize: DefaultEDNSBufferSize,
+	})
+	if err != nil {
+		t.Fatalf("could not create resolver: %s", err)
+	}
+
+	return resolver
+}
+
+func TestRawResolverNXDomainAndNoData(t *testing.T) {
+	address := startDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		switch r.Question[0].Name {
+		case "missing.example.org.":
+			msg.Rcode = dns.RcodeNameError
+		case "alias.example.org.":
+			rr, _ := dns.NewRR("alias.example.org. 60 IN CNAME target.example.org.")
+			msg.Answer = append(msg.Answer, rr)
+		}
+		w.WriteMsg(msg)
+	})
+
+	tests := []struct {
+		host      string
+		errorType string
+	}{
+		{host: "missing.example.org", errorType: ErrorTypeNXDomain},
+		{host: "empty.example.org", errorType: ErrorTypeNoData},
+		{host: "alias.example.org", errorType: ErrorTypeCNAMENoAddress},
+	}
+
+	resolver := newTestRawResolver(t, address, ProtocolUDP)
+	for _, test := range tests {
+		t.Run(test.host, func(t *testing.T) {
+			_, err := resolver.Lookup(context.Background(), Query{Host: test.host, RecordType: RecordTypeA})
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if errorType := classifyError(err); errorType != test.errorType {
+				t.Errorf("expected error type %s, got %s", test.errorType, errorType)
+			}
+		})
+	}
+}
+
+// spoofing answers every query with a reply altered by spoof.
+func spoofing(spoof func(msg *dns.Msg)) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		rr, _ := dns.NewRR(r.Question[0].Name + " 60 IN A 192.0.2.1")
+		msg.Answer = append(msg.Answer, rr)
+		spoof(msg)
+		w.WriteMsg(msg)
+	}
+}
+
+func TestRawResolverSpoofedResponses(t *testing.T) {
+	tests := []struct {
+		name  string
+		spoof func(msg *dns.Msg)
+	}{
+		{name: "id", spoof: func(msg *dns.Msg) { msg.Id++ }},
+		{name: "name", spoof: func(msg *dns.Msg) { msg.Question[0].Name = "other.example.org." }},
+		{name: "qtype", spoof: func(msg *dns.Msg) { msg.Question[0].Qtype = dns.TypeAAAA }},
+		{name: "question", spoof: func(msg *dns.Msg) { msg.Question = nil }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			address := startDNSServer(t, spoofing(test.spoof))
+
+			for _, protocol := range []string{ProtocolUDP, ProtocolTCP} {
+				resolver := newTestRawResolver(t, address, protocol)
+				_, err := resolver.Lookup(context.Background(), Query{Host: "example.org", RecordType: RecordTypeA})
+				if !errors.Is(err, errSpoofed) {
+					t.Errorf("%s: expected errSpoofed, got %v", protocol, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCollectCountsSpoofedResponses(t *testing.T) {
+	address := startDNSServer(t, spoofing(func(msg *dns.Msg) { msg.Id++ }))
+
+	config := testConfig("example.org")
+	config.Mode = ModeRaw
+	config.Resolver = address
+	collector, err := NewDNSCollector(config)
+	if err != nil {
+		t.Fatalf("could not create dns collector: %s", err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		families := gather(t, collector)
+		labels := map[string]string{"host": "example.org"}
+		if spoofed := metricValue(t, families, "dns_exporter_resolution_spoofed_total", labels); spoofed != float64(i) {
+			t.Errorf("expected %d spoofed responses, got %v", i, spoofed)
+		}
+		if success := metricValue(t, families, "dns_exporter_resolution_success", labels); success != 0 {
+			t.Errorf("expected a spoofed response to fail, got success %v", success)
+		}
+	}
+}
+
+func TestRawResolverRetriesTruncatedOverTCP(t *testing.T) {
+	address := startDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		if w.RemoteAddr().Network() == ProtocolUDP {
+			msg.Truncated = true
+		} else {
+			rr, _ := dns.NewRR(r.Question[0].Name + " 60 IN A 192.0.2.1")
+			msg.Answer = append(msg.Answer, rr)
+		}
+		w.WriteMsg(msg)
+	})
+
+	resolver := newTestRawResolver(t, address, ProtocolUDP)
+	resp, err := resolver.Lookup(context.Background(), Query{Host: "example.org", RecordType: RecordTypeA})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !resp.Truncated {
+		t.Error("expected the response to be marked truncated")
+	}
+	if !slices.Equal(resp.Answers, []string{"192.0.2.1"}) {
+		t.Errorf("expected the answers over tcp, got %v", resp.Answers)
+	}
+}
+
+func TestCollectCountsTruncatedResponses(t *testing.T) {
+	address := startDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Truncated = w.RemoteAddr().Network() == ProtocolUDP
+		rr, _ := dns.NewRR(r.Question[0].Name + " 60 IN A 192.0.2.1")
+		msg.Answer = append(msg.Answer, rr)
+		w.WriteMsg(msg)
+	})
+
+	config := testConfig("example.org")
+	config.Mode = ModeRaw
+	config.Resolver = address
+	collector, err := NewDNSCollector(config)
+	if err != nil {
+		t.Fatalf("could not create dns collector: %s", err)
+	}
+
+	families := gather(t, collector)
+	labels := map[string]string{"host": "example.org"}
+	if truncated := metricValue(t, families, "dns_exporter_resolution_truncated_total", labels); truncated != 1 {
+		t.Errorf("expected 1 truncated response, got %v", truncated)
+	}
+	if success := metricValue(t, families, "dns_exporter_resolution_success", labels); success != 1 {
+		t.Errorf("expected the retry over tcp to succeed, got success %v", success)
+	}
+}
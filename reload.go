@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// reloadHosts reloads the config and swaps the hosts probed by the collector.
+// Other settings only take effect on restart. If no hosts would be enabled,
+// an error is logged, and the reload is refused if rejectEmpty is set.
+// Listeners are swapped to the reloaded hosts they were started serving,
+// which the collector doesn't probe.
+func reloadHosts(collector *DNSCollector, listeners []*listener, path, envHosts string, rejectEmpty bool) (err error) {
+	defer func() {
+		collector.recordReload(err == nil)
+		for _, listener := range listeners {
+			listener.collector.recordReload(err == nil)
+		}
+	}()
+
+	if slices.Contains(strings.Split(path, ","), StdinConfigFile) {
+		return errors.New("config read from stdin cannot be reloaded")
+	}
+
+	config, err := loadConfig(path, envHosts)
+	if err != nil {
+		return err
+	}
+
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %s", err)
+	}
+
+	if err := checkEnabledHosts(config.Hosts, rejectEmpty); err != nil {
+		return err
+	}
+
+	hosts := unlistenedHosts(listenerConfigs(listeners), config.Hosts)
+	if err := collector.AddResolvers(hosts); err != nil {
+		return fmt.Errorf("could not create resolvers: %s", err)
+	}
+
+	collector.SetHosts(hosts)
+	for _, listener := range listeners {
+		if err := listener.setHosts(config.Hosts); err != nil {
+			return fmt.Errorf("could not create resolvers for listener '%s': %s", listener.config.Address, err)
+		}
+	}
+	slog.Info("reloaded config", "hosts", len(config.Hosts))
+
+	return nil
+}
+
+// recordReload records the outcome of a reload attempt. The time of the last
+// successful load is kept when a reload fails.
+func (e *DNSCollector) recordReload(success bool) {
+	e.lastReloadFailed.Store(!success)
+	if success {
+		e.lastReloadTime.Store(time.Now().UnixNano())
+	}
+}
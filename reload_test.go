@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestReloadHostsRecordsReloads(t *testing.T) {
+	address := startDNSServer(t, answerA("192.0.2.1"))
+	path := writeConfigFile(t, "config.yml", "resolver: "+address+"\nrecord_types: [A]\nhosts:\n  - a.example.org\n")
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("could not load config: %s", err)
+	}
+	collector, err := NewDNSCollector(config)
+	if err != nil {
+		t.Fatalf("could not create dns collector: %s", err)
+	}
+
+	reloadMetrics := func() (float64, float64) {
+		t.Helper()
+
+		families := gather(t, collector)
+		return metricValue(t, families, "dns_exporter_config_last_reload_successful", nil),
+			metricValue(t, families, "dns_exporter_config_last_reload_success_timestamp_seconds", nil)
+	}
+
+	successful, loaded := reloadMetrics()
+	if successful != 1 || loaded == 0 {
+		t.Fatalf("expected the initial load to be successful, got %v at %v", successful, loaded)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := os.WriteFile(path, []byte("resolver: "+address+"\nrecord_types: [A]\nhosts:\n  - b.example.org\n"), 0o644); err != nil {
+		t.Fatalf("could not write config file: %s", err)
+	}
+	if err := reloadHosts(collector, nil, path, "", false); err != nil {
+		t.Fatalf("could not reload config: %s", err)
+	}
+
+	successful, reloaded := reloadMetrics()
+	if successful != 1 || reloaded <= loaded {
+		t.Errorf("expected a successful reload after %v, got %v at %v", loaded, successful, reloaded)
+	}
+	if hosts := hostNames(collector.Hosts()); len(hosts) != 1 || hosts[0] != "b.example.org" {
+		t.Errorf("expected the reloaded hosts, got %v", hosts)
+	}
+
+	if err := os.WriteFile(path, []byte("hosts: [b.example.org\n"), 0o644); err != nil {
+		t.Fatalf("could not write config file: %s", err)
+	}
+	if err := reloadHosts(collector, nil, path, "", false); err == nil {
+		t.Fatal("expected an error reloading invalid yaml")
+	}
+
+	successful, failed := reloadMetrics()
+	if successful != 0 {
+		t.Errorf("expected the failed reload to be unsuccessful, got %v", successful)
+	}
+	if failed != reloaded {
+		t.Errorf("expected the timestamp of the last successful reload %v, got %v", reloaded, failed)
+	}
+	if hosts := hostNames(collector.Hosts()); len(hosts) != 1 || hosts[0] != "b.example.org" {
+		t.Errorf("expected the hosts to be kept, got %v", hosts)
+	}
+}
+
+func TestReloadHostsWithListeners(t *testing.T) {
+	address := startDNSServer(t, answerA("192.0.2.1"))
+	path := writeConfigFile(t, "config.yml", "resolver: "+address+"\nrecord_types: [A]\nhosts:\n  - a.example.org\n  - b.example.org\n")
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("could not load config: %s", err)
+	}
+	collector, err := NewDNSCollector(config)
+	if err != nil {
+		t.Fatalf("could not create dns collector: %s", err)
+	}
+	l, err := newListener(config, ListenerConfig{Address: ":9999", Hosts: []string{"b.example.org", "c.example.org"}}, "/metrics", promhttp.HandlerOpts{}, "", "")
+	if err != nil {
+		t.Fatalf("could not create listener: %s", err)
+	}
+
+	if err := os.WriteFile(path, []byte("resolver: "+address+"\nrecord_types: [A]\nhosts:\n  - a.example.org\n  - b.example.org\n  - c.example.org\n"), 0o644); err != nil {
+		t.Fatalf("could not write config file: %s", err)
+	}
+	if err := reloadHosts(collector, []*listener{l}, path, "", false); err != nil {
+		t.Fatalf("could not reload config: %s", err)
+	}
+
+	if hosts := hostNames(collector.Hosts()); !slices.Equal(hosts, []string{"a.example.org"}) {
+		t.Errorf("expected the collector to probe only [a.example.org], got %v", hosts)
+	}
+	if hosts := hostNames(l.collector.Hosts()); !slices.Equal(hosts, []string{"b.example.org", "c.example.org"}) {
+		t.Errorf("expected the listener to probe [b.example.org c.example.org], got %v", hosts)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// systemNameserversCollector exposes the nameservers the system resolver is
+// configured with, as read from resolv.conf when last loaded.
+type systemNameserversCollector struct {
+	desc *prometheus.Desc
+
+	servers      []string
+	serversMutex sync.Mutex
+}
+
+func newSystemNameserversCollector() *systemNameserversCollector {
+	c := &systemNameserversCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "system_nameserver"),
+			"A metric with a constant '1' value labeled by each nameserver configured in resolv.conf.",
+			[]string{"server"},
+			nil,
+		),
+	}
+	c.Reload()
+
+	return c
+}
+
+// Reload rereads resolv.conf. If it can't be read, an error is logged and no
+// nameservers are exposed.
+func (c *systemNameserversCollector) Reload() {
+	servers, err := systemNameservers()
+	if err != nil {
+		slog.Error("could not load system nameservers", "err", err)
+	}
+
+	c.serversMutex.Lock()
+	defer c.serversMutex.Unlock()
+
+	c.servers = servers
+}
+
+func (c *systemNameserversCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *systemNameserversCollector) Collect(ch chan<- prometheus.Metric) {
+	c.serversMutex.Lock()
+	defer c.serversMutex.Unlock()
+
+	for _, server := range c.servers {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1, server)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+// ring holds the most recent values added to it, up to its size.
+type ring struct {
+	values []float64
+	next   int
+	full   bool
+}
+
+func newRing(size int) *ring {
+	return &ring{values: make([]float64, size)}
+}
+
+func (r *ring) add(value float64) {
+	r.values[r.next] = value
+	r.next = (r.next + 1) % len(r.values)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+func (r *ring) all() []float64 {
+	if r.full {
+		return r.values
+	}
+
+	return r.values[:r.next]
+}
+
+// mean returns the mean of the values in the ring, and false if it is empty.
+func (r *ring) mean() (float64, bool) {
+	values := r.all()
+	if len(values) == 0 {
+		return 0, false
+	}
+
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+
+	return sum / float64(len(values)), true
+}
+
+// minMax returns the minimum and maximum values in the ring, and false if it
+// is empty.
+func (r *ring) minMax() (float64, float64, bool) {
+	values := r.all()
+	if len(values) == 0 {
+		return 0, 0, false
+	}
+
+	min, max := values[0], values[0]
+	for _, value := range values[1:] {
+		if value < min {
+			min = value
+		}
+		if value > max {
+			max = value
+		}
+	}
+
+	return min, max, true
+}
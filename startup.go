@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	startupCheckTimeout = 2 * time.Second
+)
+
+// StartupCheck probes every host once with each of its resolvers, as a
+// scrape would, bounded by the given timeout and without recording metrics.
+// It logs each failure and a summary of how many probes succeeded, which it
+// returns. Unlike a scrape, it doesn't rotate resolvers or record the scrape
+// duration and hosts probed.
+func (e *DNSCollector) StartupCheck(ctx context.Context, timeout time.Duration) (int, int) {
+	var succeeded, failed atomic.Int64
+	var wg sync.WaitGroup
+
+	scheduled := map[probeKey]bool{}
+	for _, host := range e.Hosts() {
+		if !host.IsEnabled() {
+			continue
+		}
+
+		for _, recordType := range e.hostRecordTypes(host) {
+			for _, key := range e.probeKeys(host, recordType, e.hostResolverAddresses(host)) {
+				if scheduled[key] {
+					continue
+				}
+				scheduled[key] = true
+
+				wg.Add(1)
+				go func(host HostConfig, key probeKey) {
+					defer wg.Done()
+
+					e.semaphore <- struct{}{}
+					defer func() { <-e.semaphore }()
+
+					ctx, cancel := context.WithTimeout(ctx, timeout)
+					defer cancel()
+
+					if err := e.probe(ctx, host, key).err; err != nil {
+						failed.Add(1)
+						slog.Warn("startup check lookup failed", "host", key.host, "qtype", key.recordType, "resolver", key.resolver, "source", key.source, "error_type", classifyError(err), "err", err)
+						return
+					}
+					succeeded.Add(1)
+				}(host, key)
+			}
+		}
+	}
+	wg.Wait()
+
+	slog.Info("startup check complete", "succeeded", succeeded.Load(), "failed", failed.Load())
+	return int(succeeded.Load()), int(failed.Load())
+}
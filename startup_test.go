@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStartupCheck(t *testing.T) {
+	config := testConfig("a.example.org", "b.example.org")
+	config.Resolvers = []string{"192.0.2.53:53", "192.0.2.54:53"}
+	config.ResolverStrategy = ResolverStrategyRoundRobin
+
+	collector := newFakeCollector(t, config, fakeResolver(func(ctx context.Context, q Query) (Response, error) {
+		return Response{Answers: []string{"192.0.2.1"}}, nil
+	}))
+	collector.resolversMutex.Lock()
+	collector.resolvers[resolverKey{address: "192.0.2.54:53", mode: collector.mode}] = fakeResolver(func(ctx context.Context, q Query) (Response, error) {
+		if q.Host == "b.example.org" {
+			return Response{}, &net.DNSError{Err: "no such host", IsNotFound: true}
+		}
+		return Response{Answers: []string{"192.0.2.1"}}, nil
+	})
+	collector.resolversMutex.Unlock()
+
+	// Every resolver of every host is checked, despite the rotation.
+	succeeded, failed := collector.StartupCheck(context.Background(), time.Second)
+	if succeeded != 3 || failed != 1 {
+		t.Errorf("expected 3 succeeded and 1 failed, got %d and %d", succeeded, failed)
+	}
+
+	if duration := collector.lastScrapeDuration.Load(); duration != 0 {
+		t.Errorf("expected no scrape duration to be recorded, got %s", time.Duration(duration))
+	}
+	if probed := collector.lastHostsProbed.Load(); probed != 0 {
+		t.Errorf("expected no hosts probed to be recorded, got %d", probed)
+	}
+	collector.rotationIndexMutex.Lock()
+	defer collector.rotationIndexMutex.Unlock()
+	if len(collector.rotationIndex) != 0 {
+		t.Errorf("expected the resolver rotation not to advance, got %v", collector.rotationIndex)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Version and Revision are set at build time, e.g.
+// go build -ldflags "-X main.Version=v0.1.0 -X main.Revision=$(git rev-parse HEAD)"
+var (
+	Version  = "unknown"
+	Revision = "unknown"
+)
+
+// userAgent is the User-Agent sent on HTTP requests made by the exporter.
+func userAgent() string {
+	return "dns-exporter/" + Version
+}
+
+func newBuildInfoCollector() prometheus.Collector {
+	buildInfo := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "build_info",
+		Help:      "A metric with a constant '1' value labeled by version, revision, and goversion.",
+		ConstLabels: prometheus.Labels{
+			"version":   Version,
+			"revision":  Revision,
+			"goversion": runtime.Version(),
+		},
+	})
+	buildInfo.Set(1)
+
+	return buildInfo
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+func validateTLSFiles(certFile, keyFile string) error {
+	if (certFile == "") != (keyFile == "") {
+		return errors.New("both -web.tls-cert-file and -web.tls-key-file must be set to enable TLS")
+	}
+
+	return nil
+}
+
+// listenAndServe serves over TLS when a certificate and key are given, and
+// plain HTTP otherwise.
+func listenAndServe(server *http.Server, certFile, keyFile string) error {
+	if certFile != "" && keyFile != "" {
+		return server.ListenAndServeTLS(certFile, keyFile)
+	}
+
+	return server.ListenAndServe()
+}
+
+func validateBasicAuth(user, passwordHash string) error {
+	if (user == "") != (passwordHash == "") {
+		return errors.New("both -web.auth-user and -web.auth-password-hash must be set to enable basic auth")
+	}
+
+	return nil
+}
+
+// basicAuth wraps the handler to require the given user and a password
+// matching the bcrypt hash, serving without auth if they are unset.
+func basicAuth(handler http.Handler, user, passwordHash string) http.Handler {
+	if user == "" && passwordHash == "" {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqUser, reqPassword, ok := r.BasicAuth()
+
+		userMatch := subtle.ConstantTimeCompare([]byte(reqUser), []byte(user)) == 1
+		passwordMatch := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(reqPassword)) == nil
+
+		if !ok || !userMatch || !passwordMatch {
+			w.Header().Set("WWW-Authenticate", `Basic realm="dns-exporter"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// failOnError wraps the handler to respond with a 500, while still serving
+// the metrics, if failing reports that a host failed after the handler ran.
+func failOnError(handler http.Handler, failing func() bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buffered := &bufferedResponseWriter{header: http.Header{}, status: http.StatusOK}
+		handler.ServeHTTP(buffered, r)
+
+		for name, values := range buffered.header {
+			w.Header()[name] = values
+		}
+
+		status := buffered.status
+		if status == http.StatusOK && failing() {
+			status = http.StatusInternalServerError
+		}
+		w.WriteHeader(status)
+		w.Write(buffered.body.Bytes())
+	})
+}
+
+// bufferedResponseWriter holds a response until it is written out, so that
+// its status can still be changed.
+type bufferedResponseWriter struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.body.Write(b)
+}
+
+func landingPageHandler(telemetryPath string) http.HandlerFunc {
+	page := fmt.Sprintf(`<html>
+<head><title>DNS Exporter</title></head>
+<body>
+<h1>DNS Exporter</h1>
+<p><a href="%s">Metrics</a></p>
+</body>
+</html>
+`, html.EscapeString(telemetryPath))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+	}
+}
+
+const (
+	redacted = "<redacted>"
+)
+
+// WebConfig holds the flags configuring the HTTP server.
+type WebConfig struct {
+	ListenAddress    string `yaml:"listen_address"`
+	TelemetryPath    string `yaml:"telemetry_path"`
+	TLSCertFile      string `yaml:"tls_cert_file"`
+	TLSKeyFile       string `yaml:"tls_key_file"`
+	AuthUser         string `yaml:"auth_user"`
+	AuthPasswordHash string `yaml:"auth_password_hash"`
+}
+
+type effectiveConfig struct {
+	Config Config    `yaml:"config"`
+	Web    WebConfig `yaml:"web"`
+}
+
+// configHandler serves the effective configuration as JSON, with secrets,
+// including DoH header values and the webhook URL, redacted. It is marshalled
+// through YAML so that field names and durations match the config file.
+func configHandler(config func() Config, web WebConfig) http.HandlerFunc {
+	if web.AuthPasswordHash != "" {
+		web.AuthPasswordHash = redacted
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := config()
+		if c.WebhookURL != "" {
+			c.WebhookURL = redacted
+		}
+		if len(c.DoHHeaders) > 0 {
+			headers := map[string]string{}
+			for name := range c.DoHHeaders {
+				headers[name] = redacted
+			}
+			c.DoHHeaders = headers
+		}
+
+		data, err := yaml.Marshal(effectiveConfig{Config: c, Web: web})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var view any
+		if err := yaml.Unmarshal(data, &view); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(view); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
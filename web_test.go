@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestConfigHandlerRedactsSecrets(t *testing.T) {
+	config := testConfig("example.org")
+	config.WebhookURL = "https://hooks.example.org/services/secret-token"
+	config.DoHHeaders = map[string]string{"Authorization": "Bearer secret-token"}
+	web := WebConfig{AuthUser: "admin", AuthPasswordHash: "$2y$10$secret-hash"}
+
+	handler := configHandler(func() Config { return config }, web)
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, secret := range []string{"secret-token", "secret-hash"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("expected %s to be redacted, got %s", secret, body)
+		}
+	}
+	for _, field := range []string{"webhook_url", "doh_headers", "auth_password_hash"} {
+		if !strings.Contains(body, field) {
+			t.Errorf("expected %s to be served redacted, got %s", field, body)
+		}
+	}
+
+	if config.WebhookURL == redacted || config.DoHHeaders["Authorization"] == redacted {
+		t.Error("expected the config itself not to be redacted")
+	}
+}
+
+func TestFailOnError(t *testing.T) {
+	for _, failing := range []bool{false, true} {
+		handler := failOnError(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("dns_exporter_resolution_success 1\n"))
+		}), func() bool { return failing })
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		status := http.StatusOK
+		if failing {
+			status = http.StatusInternalServerError
+		}
+		if rec.Code != status {
+			t.Errorf("failing %t: expected status %d, got %d", failing, status, rec.Code)
+		}
+		if contentType := rec.Header().Get("Content-Type"); contentType != "text/plain" {
+			t.Errorf("failing %t: expected the content type to be kept, got %s", failing, contentType)
+		}
+		if body := rec.Body.String(); body != "dns_exporter_resolution_success 1\n" {
+			t.Errorf("failing %t: expected the metrics to be served, got %q", failing, body)
+		}
+	}
+}
+
+func TestFailOnErrorWithCollector(t *testing.T) {
+	collector := newFakeCollector(t, testConfig("example.org"), answering("192.0.2.1"))
+	registry := prometheus.NewRegistry()
+	if err := collector.Register(registry); err != nil {
+		t.Fatalf("could not register collector: %s", err)
+	}
+	handler := failOnError(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}), collector.Failing)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status 200 when every host resolves, got %d", rec.Code)
+	}
+
+	collector.resolversMutex.Lock()
+	for key := range collector.resolvers {
+		collector.resolvers[key] = fakeResolver(func(ctx context.Context, q Query) (Response, error) {
+			return Response{}, &net.DNSError{Err: "no such host", IsNotFound: true}
+		})
+	}
+	collector.resolversMutex.Unlock()
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500 when a host fails, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `dns_exporter_resolution_success{`) {
+		t.Errorf("expected the metrics to still be served, got %s", rec.Body.String())
+	}
+}
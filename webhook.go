@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	WebhookStateSuccess = "success"
+	WebhookStateFailure = "failure"
+
+	// webhookTimeout bounds each request to the webhook.
+	webhookTimeout = 10 * time.Second
+)
+
+// webhookPayload is the JSON posted to the webhook when a probe changes state.
+type webhookPayload struct {
+	Host       string    `json:"host"`
+	RecordType string    `json:"qtype"`
+	Resolver   string    `json:"resolver"`
+	State      string    `json:"state"`
+	Error      string    `json:"error,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// webhookState is the state last notified for a probe, and when.
+type webhookState struct {
+	success bool
+	time    time.Time
+}
+
+// webhook posts to a URL when probes change between success and failure. A
+// change within the cooldown of the last one notified for the probe is only
+// posted once the cooldown has passed, and not at all if it changed back, so
+// that flapping isn't notified on every probe. The first result of a probe
+// is taken as its initial state, and isn't posted.
+type webhook struct {
+	url      string
+	cooldown time.Duration
+	client   *http.Client
+
+	states      map[probeKey]webhookState
+	statesMutex sync.Mutex
+}
+
+func newWebhook(url string, cooldown time.Duration) *webhook {
+	return &webhook{
+		url:      url,
+		cooldown: cooldown,
+		client:   &http.Client{Timeout: webhookTimeout},
+		states:   map[probeKey]webhookState{},
+	}
+}
+
+// observe records the result of probing the key, posting to the webhook in
+// the background if the probe changed state.
+func (w *webhook) observe(key probeKey, err error) {
+	now := time.Now()
+
+	w.statesMutex.Lock()
+	state, ok := w.states[key]
+	if !ok || state.success == (err == nil) || now.Sub(state.time) < w.cooldown {
+		if !ok {
+			w.states[key] = webhookState{success: err == nil}
+		}
+		w.statesMutex.Unlock()
+		return
+	}
+	w.states[key] = webhookState{success: err == nil, time: now}
+	w.statesMutex.Unlock()
+
+	payload := webhookPayload{
+		Host:       key.host,
+		RecordType: key.recordType,
+		Resolver:   key.resolver,
+		State:      WebhookStateSuccess,
+		Timestamp:  now,
+	}
+	if err != nil {
+		payload.State = WebhookStateFailure
+		payload.Error = normalizeError(key.host, err)
+	}
+
+	go func() {
+		if err := w.post(payload); err != nil {
+			slog.Error("could not post to webhook", "host", key.host, "qtype", key.recordType, "resolver", key.resolver, "state", payload.State, "err", err)
+		}
+	}()
+}
+
+func (w *webhook) post(payload webhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent())
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// startWebhookServer returns the URL of a webhook sending each payload posted
+// to it on the channel.
+func startWebhookServer(t *testing.T) (string, <-chan webhookPayload) {
+	t.Helper()
+
+	payloads := make(chan webhookPayload, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload webhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		payloads <- payload
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL, payloads
+}
+
+// expectPayload fails the test unless a payload of the state is posted.
+func expectPayload(t *testing.T, payloads <-chan webhookPayload, state string) {
+	t.Helper()
+
+	select {
+	case payload := <-payloads:
+		if payload.State != state {
+			t.Errorf("expected a %s payload, got %s", state, payload.State)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected a %s payload to be posted", state)
+	}
+}
+
+// expectNoPayload fails the test if a payload is posted.
+func expectNoPayload(t *testing.T, payloads <-chan webhookPayload) {
+	t.Helper()
+
+	select {
+	case payload := <-payloads:
+		t.Errorf("expected no payload, got %s", payload.State)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWebhookObserve(t *testing.T) {
+	url, payloads := startWebhookServer(t)
+	webhook := newWebhook(url, 0)
+	key := probeKey{host: "example.org", recordType: RecordTypeA, resolver: SystemResolver}
+	failure := errors.New("boom")
+
+	// The first result is the initial state, and isn't posted.
+	webhook.observe(key, nil)
+	expectNoPayload(t, payloads)
+
+	webhook.observe(key, failure)
+	expectPayload(t, payloads, WebhookStateFailure)
+
+	webhook.observe(key, failure)
+	expectNoPayload(t, payloads)
+
+	webhook.observe(key, nil)
+	expectPayload(t, payloads, WebhookStateSuccess)
+}
+
+func TestWebhookCooldown(t *testing.T) {
+	url, payloads := startWebhookServer(t)
+	cooldown := 100 * time.Millisecond
+	webhook := newWebhook(url, cooldown)
+	key := probeKey{host: "example.org", recordType: RecordTypeA, resolver: SystemResolver}
+	failure := errors.New("boom")
+
+	webhook.observe(key, nil)
+	webhook.observe(key, failure)
+	expectPayload(t, payloads, WebhookStateFailure)
+
+	// A flap back and forth within the cooldown isn't posted at all.
+	webhook.observe(key, nil)
+	webhook.observe(key, failure)
+	time.Sleep(cooldown)
+	webhook.observe(key, failure)
+	expectNoPayload(t, payloads)
+
+	// Once the cooldown has passed, a change is posted straight away.
+	webhook.observe(key, nil)
+	expectPayload(t, payloads, WebhookStateSuccess)
+
+	// A change within the cooldown is posted once it has passed.
+	webhook.observe(key, failure)
+	expectNoPayload(t, payloads)
+	time.Sleep(cooldown)
+	webhook.observe(key, failure)
+	expectPayload(t, payloads, WebhookStateFailure)
+}
+
+func TestNewDNSCollectorDefaultsWebhookCooldown(t *testing.T) {
+	config, err := LoadConfig(writeConfigFile(t, "config.yml", "webhook_url: http://127.0.0.1:9/\nhosts:\n  - example.org\n"))
+	if err != nil {
+		t.Fatalf("could not load config: %s", err)
+	}
+
+	collector, err := NewDNSCollector(config)
+	if err != nil {
+		t.Fatalf("could not create dns collector: %s", err)
+	}
+	if collector.webhook.cooldown != DefaultWebhookCooldown {
+		t.Errorf("expected the default cooldown of %s, got %s", DefaultWebhookCooldown, collector.webhook.cooldown)
+	}
+}